@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/argon2"
+)
+
+// Accounts are users that last. Logging in makes a session in tokens like a
+// guest's, with account_id set, so anything keyed by token works for both
+// and game_history can follow the account once the session is gone.
+
+// argon2id, RFC 9106's second recommended parameters
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	argonSaltLen = 16
+	argonKeyLen  = 32
+
+	minPasswordLength = 8
+	maxPasswordLength = 256
+)
+
+// hashPassword returns the PHC string, parameters and salt included, so they
+// can change without breaking stored hashes
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func verifyPassword(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, err
+	}
+
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	want, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+// hashed once at startup, logins for unknown names check against it so they
+// take as long as real ones
+var dummyPasswordHash, _ = hashPassword("not a real password")
+
+func checkPasswordPolicy(password string) error {
+	if password == "" {
+		return ErrMissingPassword
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return ErrBadPasswordLength.withDetail(fmt.Sprintf("%d to %d bytes", minPasswordLength, maxPasswordLength))
+	}
+	return nil
+}
+
+// newSession makes a token for username, idle. accountID is uuid.Nil for guests.
+func (e *env) newSession(ctx context.Context, username string, accountID uuid.UUID) (uuid.UUID, error) {
+	tx, err := e.db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, ErrSQL
+	}
+
+	defer tx.Rollback(ctx)
+
+	var account *string
+	if accountID != uuid.Nil {
+		id := accountID.String()
+		account = &id
+	}
+
+	newu := newUser(username)
+	_, err = tx.Exec(ctx, "INSERT INTO tokens (username, name_key, token, lastaccess, account_id) VALUES ($1, $2, $3, NOW(), $4)", newu.Name, usernameKey(newu.Name), newu.Token.String(), account)
+	if err != nil {
+		return uuid.Nil, ErrSQL
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO user_status (user_token, user_status) VALUES ($1, $2)", newu.Token.String(), "idle")
+	if err != nil {
+		return uuid.Nil, ErrSQL
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, ErrSQL
+	}
+	return newu.Token, nil
+}
+
+// usernameInUse tells whether an account or a guest session has a name with
+// key, see usernameKey
+func (e *env) usernameInUse(ctx context.Context, key string) (bool, error) {
+	var inUse bool
+	err := e.db.QueryRow(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM accounts WHERE name_key = $1) OR
+			EXISTS (SELECT 1 FROM tokens WHERE name_key = $1 AND account_id IS NULL)
+	`, key).Scan(&inUse)
+	if err != nil {
+		return false, ErrSQL
+	}
+	return inUse, nil
+}
+
+func (e *env) postAccount(c *gin.Context) {
+	username, key, err := e.names.check(c.PostForm("username"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	password := c.PostForm("password")
+	if err := checkPasswordPolicy(password); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	inUse, err := e.usernameInUse(context.Background(), key)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if inUse {
+		respondError(c, ErrUsernameTaken)
+		return
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		respondError(c, ErrInternal)
+		return
+	}
+
+	// UNIQUE(name_key) settles a race between two registrations
+	_, err = e.db.Exec(context.Background(), "INSERT INTO accounts (account_id, username, name_key, password_hash) VALUES ($1, $2, $3, $4)", uuid.New().String(), username, key, hash)
+	if err != nil {
+		respondError(c, ErrUsernameTaken)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{"message": "account created, log in to play"})
+}
+
+func (e *env) login(c *gin.Context) {
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+
+	if username == "" {
+		respondError(c, ErrMissingUsername)
+		return
+	}
+
+	if password == "" {
+		respondError(c, ErrMissingPassword)
+		return
+	}
+
+	var accountIDString, hash string
+	err := e.db.QueryRow(context.Background(), "SELECT account_id, password_hash FROM accounts WHERE username = $1", username).Scan(&accountIDString, &hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		verifyPassword(password, dummyPasswordHash)
+		respondError(c, ErrBadLogin)
+		return
+	}
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	ok, err := verifyPassword(password, hash)
+	if err != nil {
+		respondError(c, ErrInternal)
+		return
+	}
+
+	if !ok {
+		respondError(c, ErrBadLogin)
+		return
+	}
+
+	accountID, _ := uuid.Parse(accountIDString)
+	token, err := e.newSession(context.Background(), username, accountID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{"token": e.issueToken(token, username, accountID), "message": "logged in"})
+}
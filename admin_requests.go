@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Operator endpoints under /admin. Matches only live on their host node, so
+// listing shows this node's and the rest redirect to the match's node like
+// the game routes do.
+
+// AdminMatchSummary is one live match on this node
+type AdminMatchSummary struct {
+	MatchID    string    `json:"matchID"`
+	Host       string    `json:"host"`
+	Guest      string    `json:"guest"`
+	Turn       string    `json:"turn,omitempty"` // who fires next, empty once it's over
+	Moves      int       `json:"moves"`
+	Over       bool      `json:"over"`
+	StartedAt  time.Time `json:"startedAt"`
+	AgeSeconds int       `json:"ageSeconds"`
+}
+
+// AdminMatchView is a live match with nothing hidden
+type AdminMatchView struct {
+	Summary    AdminMatchSummary `json:"summary"`
+	HostToken  string            `json:"hostToken"`
+	GuestToken string            `json:"guestToken"`
+	State      *FullGameState    `json:"state"`
+}
+
+type adminMatchesResponse struct {
+	Node    string              `json:"node"`
+	Matches []AdminMatchSummary `json:"matches"`
+}
+
+// matchNames is the players' names for each of ids, from games
+func (e *env) matchNames(ctx context.Context, ids []string) (map[string][2]string, error) {
+	rows, _ := e.db.Query(ctx, "SELECT game_id::text, COALESCE(player_one_name, ''), COALESCE(player_two_name, '') FROM games WHERE game_id::text = ANY($1)", ids)
+	names := map[string][2]string{}
+	var id, host, guest string
+	_, err := pgx.ForEachRow(rows, []any{&id, &host, &guest}, func() error {
+		names[id] = [2]string{host, guest}
+		return nil
+	})
+	return names, err
+}
+
+// summarize wants match.mu held
+func summarize(matchID uuid.UUID, match *Match, names [2]string) AdminMatchSummary {
+	gs := match.GameState
+	s := AdminMatchSummary{
+		MatchID: matchID.String(),
+		Host:    names[0],
+		Guest:   names[1],
+		Moves:   len(gs.moves),
+		Over:    gs.winner != NoneWinner,
+	}
+	if !s.Over {
+		s.Turn = s.Host
+		if (gs.evens == Host) != (len(gs.moves)%2 == 0) {
+			s.Turn = s.Guest
+		}
+	}
+	if len(gs.events) > 0 {
+		s.StartedAt = gs.events[0].At
+		s.AgeSeconds = int(time.Since(s.StartedAt).Seconds())
+	}
+	return s
+}
+
+// adminMatches lists the matches in this node's memory, oldest first
+func (e *env) adminMatches(c *gin.Context) {
+	live := map[uuid.UUID]*Match{}
+	var ids []string
+	e.matches.Range(func(key, value any) bool {
+		live[key.(uuid.UUID)] = value.(*Match)
+		ids = append(ids, key.(uuid.UUID).String())
+		return true
+	})
+
+	names, err := e.matchNames(context.Background(), ids)
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	matches := []AdminMatchSummary{}
+	for id, match := range live {
+		match.mu.Lock()
+		matches = append(matches, summarize(id, match, names[id.String()]))
+		match.mu.Unlock()
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].StartedAt.Before(matches[j].StartedAt) })
+
+	c.IndentedJSON(http.StatusOK, adminMatchesResponse{Node: e.cfg.AdvertiseAddr, Matches: matches})
+}
+
+// adminMatch finds :match_id on this node for the handlers after it, or
+// redirects to the node hosting it
+func (e *env) adminMatch(c *gin.Context) {
+	matchID, err := uuid.Parse(c.Param("match_id"))
+	if err != nil {
+		abortWithError(c, ErrMalformedMatchID)
+		return
+	}
+
+	hostAddr, err := e.matchHost(context.Background(), matchID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if hostAddr != e.cfg.AdvertiseAddr {
+		c.IndentedJSON(http.StatusFound, gin.H{"message": "redirect requests to host server", "location": "http://" + hostAddr, "match_id": matchID.String()})
+		c.Abort()
+		return
+	}
+
+	match, err := e.liveMatch(context.Background(), matchID)
+	if err != nil {
+		abortWithError(c, ErrMatchNotLoaded)
+		return
+	}
+
+	c.Set("match", match)
+	c.Set("matchToken", matchID)
+}
+
+// adminInspect shows a live match in full, both fleets included
+func (e *env) adminInspect(c *gin.Context) {
+	match := c.MustGet("match").(*Match)
+	matchID := c.MustGet("matchToken").(uuid.UUID)
+
+	names, err := e.matchNames(context.Background(), []string{matchID.String()})
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	match.mu.Lock()
+	defer match.mu.Unlock()
+	c.IndentedJSON(http.StatusOK, AdminMatchView{
+		Summary:    summarize(matchID, match, names[matchID.String()]),
+		HostToken:  match.HostToken.String(),
+		GuestToken: match.GuestToken.String(),
+		State:      match.GameState.toFull(),
+	})
+}
+
+// adminEndMatch ends a live match. outcome "host" or "guest" is the winner,
+// the other forfeits and it's recorded and rated as usual. "abandon" throws
+// the match away unrated, for games that are broken rather than lost. A
+// match that's already over can't be ended again.
+func (e *env) adminEndMatch(c *gin.Context) {
+	match := c.MustGet("match").(*Match)
+	matchID := c.MustGet("matchToken").(uuid.UUID)
+	ctx := context.Background()
+
+	outcome := c.PostForm("outcome")
+	var err error
+	switch outcome {
+	case "host":
+		err = e.endMatch(ctx, matchID, match, Guest)
+	case "guest":
+		err = e.endMatch(ctx, matchID, match, Host)
+	case "abandon":
+		err = e.abandonMatch(ctx, matchID, match)
+	default:
+		respondError(c, ErrBadOutcome.withDetail("host, guest or abandon"))
+		return
+	}
+	switch {
+	case errors.Is(err, ErrGameOver):
+		respondError(c, ErrMatchOver)
+		return
+	case errors.Is(err, ErrBadEvent):
+		respondError(c, ErrCorruptGame)
+		return
+	case err != nil:
+		respondError(c, ErrSQL)
+		return
+	}
+
+	claims := c.MustGet("claims").(*sessionClaims)
+	log.Printf("%s ended match %s: %s\n", claims.Username, matchID, outcome)
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "match ended: " + outcome})
+}
+
+// abandonMatch drops a match without a result. Its events stay, so it can
+// still be replayed up to where it stopped. Finished matches have their
+// result already, that's ErrGameOver.
+func (e *env) abandonMatch(ctx context.Context, matchID uuid.UUID, match *Match) error {
+	match.mu.Lock()
+	defer match.mu.Unlock()
+
+	if match.GameState.winner != NoneWinner {
+		return ErrGameOver
+	}
+	e.matches.Delete(matchID)
+
+	tx, err := e.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err := releasePlayers(ctx, tx, match); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM games WHERE game_id = $1", matchID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// adminKick ends every session username has, forfeiting their matches. A
+// moderator can't kick someone of their own rank or higher. If a match of
+// theirs is on another node the rest is done and it redirects there. Guests
+// have no account, so no role.
+func (e *env) adminKick(c *gin.Context) {
+	username := c.Param("username")
+	ctx := context.Background()
+
+	var role string
+	err := e.db.QueryRow(ctx, "SELECT COALESCE((SELECT role FROM accounts WHERE username = $1), $2)", username, "").Scan(&role)
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+	mine := c.MustGet("role").(string)
+	if roleRank[role] >= roleRank[mine] && mine != roleAdmin {
+		respondError(c, ErrForbidden.withDetail("can't kick a "+role))
+		return
+	}
+
+	rows, _ := e.db.Query(ctx, "SELECT token FROM tokens WHERE username = $1", username)
+	var sessions []uuid.UUID
+	var tokenString string
+	_, err = pgx.ForEachRow(rows, []any{&tokenString}, func() error {
+		if id, err := uuid.Parse(tokenString); err == nil {
+			sessions = append(sessions, id)
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	if len(sessions) == 0 {
+		respondError(c, ErrUserNotFound)
+		return
+	}
+
+	var elsewhere string
+	ended := 0
+	for _, sid := range sessions {
+		hostAddr, err := e.endSession(ctx, sid)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if hostAddr != "" {
+			elsewhere = hostAddr
+			continue
+		}
+		ended++
+	}
+
+	claims := c.MustGet("claims").(*sessionClaims)
+	log.Printf("%s kicked %s, %d sessions ended\n", claims.Username, username, ended)
+
+	if elsewhere != "" {
+		c.IndentedJSON(http.StatusFound, gin.H{"message": "in a match on another node, redirect requests to host server", "location": "http://" + elsewhere})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "kicked " + username})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIError is the body of every error response. Code is stable, clients
+// should branch on it. Message is for humans and may change whenever.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	if e.Detail != "" {
+		return e.Code + ": " + e.Message + " (" + e.Detail + ")"
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Is compares codes, so errors.Is still works on copies made by withDetail
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code == e.Code
+}
+
+// withDetail returns a copy of e, never modify the package level errors
+func (e *APIError) withDetail(detail string) *APIError {
+	cp := *e
+	cp.Detail = detail
+	return &cp
+}
+
+// general
+var (
+	ErrSQL           = newAPIError(http.StatusInternalServerError, "sql_error", "Unknown SQL error. Contact Admins. Or don't.")
+	ErrInternal      = newAPIError(http.StatusInternalServerError, "internal_error", "something went wrong on our side")
+	ErrInternalComms = newAPIError(http.StatusInternalServerError, "internal_communication_error", "internal communication error")
+	ErrNodeDraining  = newAPIError(http.StatusServiceUnavailable, "node_draining", "node is shutting down, try another one")
+)
+
+// users and sessions
+var (
+	ErrMissingUsername    = newAPIError(http.StatusBadRequest, "missing_username", "no username supplied")
+	ErrUsernameTooLong    = newAPIError(http.StatusBadRequest, "username_too_long", "username too long")
+	ErrUsernameTooShort   = newAPIError(http.StatusBadRequest, "username_too_short", "username too short")
+	ErrBadUsername        = newAPIError(http.StatusBadRequest, "bad_username", "username has characters that aren't allowed")
+	ErrUsernameReserved   = newAPIError(http.StatusBadRequest, "username_reserved", "username is reserved")
+	ErrUsernameNotAllowed = newAPIError(http.StatusBadRequest, "username_not_allowed", "username not allowed")
+	ErrUsernameTaken      = newAPIError(http.StatusConflict, "username_taken", "username taken")
+	ErrMissingToken       = newAPIError(http.StatusUnauthorized, "missing_token", "no token supplied")
+	ErrMalformedToken     = newAPIError(http.StatusUnauthorized, "malformed_token", "not a session token")
+	ErrInvalidToken       = newAPIError(http.StatusUnauthorized, "invalid_token", "token is forged or its session has ended")
+	ErrExpiredToken       = newAPIError(http.StatusUnauthorized, "expired_token", "token expired")
+	ErrMissingPassword    = newAPIError(http.StatusBadRequest, "missing_password", "no password supplied")
+	ErrBadPasswordLength  = newAPIError(http.StatusBadRequest, "bad_password_length", "password too short or too long")
+	ErrBadLogin           = newAPIError(http.StatusUnauthorized, "bad_login", "wrong username or password")
+	ErrNotAccount         = newAPIError(http.StatusForbidden, "not_account", "guests only have the one session")
+	ErrSessionNotFound    = newAPIError(http.StatusNotFound, "session_not_found", "no such session on this account")
+)
+
+// hosting and matchmaking
+var (
+	ErrUserNotIdle    = newAPIError(http.StatusConflict, "user_not_idle", "user not idle")
+	ErrUserNotHosting = newAPIError(http.StatusConflict, "user_not_hosting", "user is not hosting")
+	ErrNoHosts        = newAPIError(http.StatusNotFound, "no_hosts", "no match hosts found, consider hosting")
+	ErrNotPlaying     = newAPIError(http.StatusNotFound, "not_playing", "User not in playing state.")
+	ErrUnknownRules   = newAPIError(http.StatusBadRequest, "unknown_rules", "no such rules")
+	ErrBadBoardSize   = newAPIError(http.StatusBadRequest, "bad_board_size", "board size out of range")
+	ErrHostGone       = newAPIError(http.StatusConflict, "host_gone", "that user isn't hosting anymore")
+	ErrJoinSelf       = newAPIError(http.StatusBadRequest, "join_self", "can't join your own match")
+	ErrBadPrivate     = newAPIError(http.StatusBadRequest, "bad_private", "private must be true or false")
+	ErrNotQueued      = newAPIError(http.StatusConflict, "not_queued", "user is not queued")
+	ErrInviteNotFound = newAPIError(http.StatusNotFound, "invite_not_found", "nobody is waiting with that invite code")
+)
+
+// playing
+var (
+	ErrGameStateCreation = newAPIError(http.StatusInternalServerError, "game_state_error", "could not create game state")
+	ErrMissingMatchID    = newAPIError(http.StatusBadRequest, "missing_match_id", "no match token supplied")
+	ErrMalformedMatchID  = newAPIError(http.StatusBadRequest, "malformed_match_id", "match token not UUID")
+	ErrMatchNotLoaded    = newAPIError(http.StatusBadRequest, "match_not_loaded", "could not retrieve match from memory")
+	ErrNotInMatch        = newAPIError(http.StatusBadRequest, "not_in_match", "player not in match")
+	ErrMissingCoordinate = newAPIError(http.StatusBadRequest, "missing_coordinate", "coord not supplied")
+	ErrInvalidCoordinate = newAPIError(http.StatusBadRequest, "invalid_coordinate", "coord not an integer")
+	ErrNotYourTurn       = newAPIError(http.StatusBadRequest, "not_your_turn", "incorrect player order")
+	ErrShotOutOfBounds   = newAPIError(http.StatusBadRequest, "shot_out_of_bounds", "hit is out of bounds")
+	ErrMatchOver         = newAPIError(http.StatusConflict, "match_over", "match is already over")
+	ErrGameNotFound      = newAPIError(http.StatusNotFound, "game_not_found", "no such game")
+	ErrBadMoveNumber     = newAPIError(http.StatusBadRequest, "bad_move_number", "move must be a number between 0 and the moves played")
+	ErrCorruptGame       = newAPIError(http.StatusInternalServerError, "corrupt_game", "game log doesn't replay")
+	ErrMatchNotOver      = newAPIError(http.StatusConflict, "match_not_over", "only finished games can be exported")
+	ErrMissingNotation   = newAPIError(http.StatusBadRequest, "missing_notation", "no notation supplied")
+	ErrInvalidNotation   = newAPIError(http.StatusUnprocessableEntity, "invalid_notation", "notation doesn't parse or doesn't follow the rules")
+)
+
+// ratings
+var (
+	ErrNotRated = newAPIError(http.StatusNotFound, "not_rated", "no rated games for that user")
+)
+
+// internal
+var (
+	ErrMissingGameID    = newAPIError(http.StatusBadRequest, "missing_game_id", "No game ID supplied. Malformed internal request?!")
+	ErrBadMatchImport   = newAPIError(http.StatusBadRequest, "bad_match_import", "match to import is missing or malformed")
+	ErrMissingSignature = newAPIError(http.StatusBadRequest, "missing_signature", "Request isn't signed. Malformed internal request?!")
+	ErrBadSignature     = newAPIError(http.StatusForbidden, "bad_signature", "GET OUT")
+)
+
+// roles and admin
+var (
+	ErrForbidden       = newAPIError(http.StatusForbidden, "forbidden", "your account's role doesn't allow that")
+	ErrUnknownRole     = newAPIError(http.StatusBadRequest, "unknown_role", "no such role")
+	ErrAccountNotFound = newAPIError(http.StatusNotFound, "account_not_found", "no such account")
+	ErrUserNotFound    = newAPIError(http.StatusNotFound, "user_not_found", "nobody by that name is logged in")
+	ErrBadOutcome      = newAPIError(http.StatusBadRequest, "bad_outcome", "unknown match outcome")
+)
+
+// respondError writes err as the response body. Anything that isn't an
+// *APIError goes out as ErrInternal so we don't leak internals to clients.
+// Every 401 comes with a Bearer challenge, RFC 6750 style.
+func respondError(c *gin.Context, err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = ErrInternal
+	}
+	if apiErr.Status == http.StatusUnauthorized {
+		challenge := `Bearer realm="battlegov3"`
+		if apiErr.Code != ErrMissingToken.Code && apiErr.Code != ErrBadLogin.Code {
+			challenge += `, error="invalid_token", error_description="` + apiErr.Message + `"`
+		}
+		c.Header("WWW-Authenticate", challenge)
+	}
+	c.IndentedJSON(apiErr.Status, apiErr)
+}
+
+// abortWithError is respondError for middleware
+func abortWithError(c *gin.Context, err error) {
+	respondError(c, err)
+	c.Abort()
+}
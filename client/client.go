@@ -0,0 +1,441 @@
+// Package client wraps the battlegov3 HTTP API.
+//
+// A Client talks to the node it was created with until a match starts. Match
+// sessions only live on one node, so when the server answers with its 302
+// "talk to the host server" the client remembers that node and sends every
+// game request there.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const apiPrefix = "/v1"
+
+// Client is not safe for concurrent use, make one per player
+type Client struct {
+	// BaseURL of the node to talk to outside of a match, e.g. "http://localhost:8080"
+	BaseURL string
+	// HTTPClient defaults to http.DefaultClient. Redirects are never followed
+	// by it, the client handles them itself.
+	HTTPClient *http.Client
+	// Token is set by Register, set it yourself to resume a session
+	Token string
+
+	matchID  string
+	matchURL string
+}
+
+// New returns a client for the node at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// MatchID of the current match, empty until JoinMatch or Match finds one
+func (c *Client) MatchID() string {
+	return c.matchID
+}
+
+// MatchURL is the node hosting the current match
+func (c *Client) MatchURL() string {
+	if c.matchURL == "" {
+		return c.BaseURL
+	}
+	return c.matchURL
+}
+
+// Register creates a throwaway user and keeps its token
+func (c *Client) Register(ctx context.Context, username string) error {
+	var body struct {
+		Token string `json:"token"`
+	}
+	form := url.Values{"username": {username}}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, "/user/"+url.PathEscape(username), form, &body); err != nil {
+		return err
+	}
+	c.Token = body.Token
+	return nil
+}
+
+// CreateAccount registers username for good. It doesn't log in, call Login.
+func (c *Client) CreateAccount(ctx context.Context, username, password string) error {
+	form := url.Values{"username": {username}, "password": {password}}
+	return c.do(ctx, c.BaseURL, http.MethodPost, "/accounts", form, nil)
+}
+
+// Login starts a session for an account and keeps its token
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	var body struct {
+		Token string `json:"token"`
+	}
+	form := url.Values{"username": {username}, "password": {password}}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, "/login", form, &body); err != nil {
+		return err
+	}
+	c.Token = body.Token
+	return nil
+}
+
+// ExtendSession swaps the token for a fresh one. Only needed after a long
+// quiet spell, any request with a token past half its life gets a fresh one
+// back and the client keeps it.
+func (c *Client) ExtendSession(ctx context.Context) error {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, "/extendSession", c.authForm(), &body); err != nil {
+		return err
+	}
+	c.Token = body.Token
+	return nil
+}
+
+// Logout ends the session, forfeiting any match it's in, and forgets the token
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.endSession(ctx, http.MethodPost, "/logout"); err != nil {
+		return err
+	}
+	c.Token = ""
+	return nil
+}
+
+// Sessions lists the account's live sessions. Guests get CodeNotAccount.
+func (c *Client) Sessions(ctx context.Context) ([]Session, error) {
+	var body struct {
+		Sessions []Session `json:"sessions"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodGet, "/sessions", c.authForm(), &body); err != nil {
+		return nil, err
+	}
+	return body.Sessions, nil
+}
+
+// EndSession ends one of the account's sessions by ID, as listed by Sessions
+func (c *Client) EndSession(ctx context.Context, id string) error {
+	return c.endSession(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(id))
+}
+
+// endSession follows the redirect to the match's node when there is one,
+// only that node can forfeit it
+func (c *Client) endSession(ctx context.Context, method, path string) error {
+	var body struct {
+		Location string `json:"location"`
+	}
+	if err := c.do(ctx, c.BaseURL, method, path, c.authForm(), &body); err != nil {
+		return err
+	}
+	if body.Location != "" {
+		return c.do(ctx, body.Location, method, path, c.authForm(), nil)
+	}
+	return nil
+}
+
+// HostMatch puts the user in the pool of hosts others can join
+func (c *Client) HostMatch(ctx context.Context) error {
+	return c.do(ctx, c.BaseURL, http.MethodPost, "/hostMatch", c.authForm(), nil)
+}
+
+// HostMatchRules is HostMatch with a rule set ("classic", "fleet") and board
+// size. Empty rules and size 0 leave them to the server.
+func (c *Client) HostMatchRules(ctx context.Context, rules string, boardSize int) error {
+	form := c.authForm()
+	if rules != "" {
+		form.Set("rules", rules)
+	}
+	if boardSize != 0 {
+		form.Set("board_size", strconv.Itoa(boardSize))
+	}
+	return c.do(ctx, c.BaseURL, http.MethodPost, "/hostMatch", form, nil)
+}
+
+// HostPrivate hosts out of the lobby and random joins, share the returned
+// invite code with whoever should join. Rules and size as in HostMatchRules.
+func (c *Client) HostPrivate(ctx context.Context, rules string, boardSize int) (string, error) {
+	form := c.authForm()
+	form.Set("private", "true")
+	if rules != "" {
+		form.Set("rules", rules)
+	}
+	if boardSize != 0 {
+		form.Set("board_size", strconv.Itoa(boardSize))
+	}
+	var body struct {
+		InviteCode string `json:"invite_code"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, "/hostMatch", form, &body); err != nil {
+		return "", err
+	}
+	return body.InviteCode, nil
+}
+
+// UnhostMatch takes the user out of the pool of hosts
+func (c *Client) UnhostMatch(ctx context.Context) error {
+	return c.do(ctx, c.BaseURL, http.MethodDelete, "/hostMatch", c.authForm(), nil)
+}
+
+// JoinMatch queues for the closest rated opponent and returns the match ID.
+// The ID is empty when nobody fits yet, poll Match until it isn't, or
+// LeaveQueue to give up.
+func (c *Client) JoinMatch(ctx context.Context) (string, error) {
+	var body struct {
+		MatchID  string `json:"matchID"`
+		Location string `json:"location"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, "/joinMatch", c.authForm(), &body); err != nil {
+		return "", err
+	}
+	c.matchID = body.MatchID
+	c.matchURL = body.Location
+	return body.MatchID, nil
+}
+
+// LeaveQueue takes the user out of the queue JoinMatch put them in
+func (c *Client) LeaveQueue(ctx context.Context) error {
+	return c.do(ctx, c.BaseURL, http.MethodDelete, "/queue", c.authForm(), nil)
+}
+
+// Lobby lists hosting users, longest waiting first
+func (c *Client) Lobby(ctx context.Context) ([]LobbyEntry, error) {
+	var body struct {
+		Hosts []LobbyEntry `json:"hosts"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodGet, "/lobby", url.Values{}, &body); err != nil {
+		return nil, err
+	}
+	return body.Hosts, nil
+}
+
+// JoinHost joins a user from the lobby and returns the match ID. Errors with
+// CodeHostGone if someone else got there first.
+func (c *Client) JoinHost(ctx context.Context, username string) (string, error) {
+	var body struct {
+		MatchID  string `json:"matchID"`
+		Location string `json:"location"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, "/lobby/"+url.PathEscape(username), c.authForm(), &body); err != nil {
+		return "", err
+	}
+	c.matchID = body.MatchID
+	c.matchURL = body.Location
+	return body.MatchID, nil
+}
+
+// JoinInvite joins a private host and returns the match ID
+func (c *Client) JoinInvite(ctx context.Context, code string) (string, error) {
+	var body struct {
+		MatchID  string `json:"matchID"`
+		Location string `json:"location"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, "/invite/"+url.PathEscape(code), c.authForm(), &body); err != nil {
+		return "", err
+	}
+	c.matchID = body.MatchID
+	c.matchURL = body.Location
+	return body.MatchID, nil
+}
+
+// Rating fetches a player's rating, errors with CodeNotRated before their
+// first finished game
+func (c *Client) Rating(ctx context.Context, username string) (*Rating, error) {
+	r := &Rating{}
+	if err := c.do(ctx, c.BaseURL, http.MethodGet, "/rating/"+url.PathEscape(username), url.Values{}, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetRole makes username a "player", "moderator" or "admin". Needs an admin
+// account.
+func (c *Client) SetRole(ctx context.Context, username, role string) error {
+	form := c.authForm()
+	form.Set("role", role)
+	return c.do(ctx, c.BaseURL, http.MethodPut, "/admin/accounts/"+url.PathEscape(username)+"/role", form, nil)
+}
+
+// AdminMatches lists the live matches on the node the client talks to.
+// Needs a moderator account.
+func (c *Client) AdminMatches(ctx context.Context) ([]AdminMatch, error) {
+	var body struct {
+		Matches []AdminMatch `json:"matches"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodGet, "/admin/matches", c.authForm(), &body); err != nil {
+		return nil, err
+	}
+	return body.Matches, nil
+}
+
+// EndMatch ends a live match: outcome "host" or "guest" wins it for them,
+// "abandon" drops it unrated. Needs an admin account.
+func (c *Client) EndMatch(ctx context.Context, matchID, outcome string) error {
+	form := c.authForm()
+	form.Set("outcome", outcome)
+	path := "/admin/matches/" + url.PathEscape(matchID) + "/end"
+	var body struct {
+		Location string `json:"location"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodPost, path, form, &body); err != nil {
+		return err
+	}
+	if body.Location != "" {
+		return c.do(ctx, body.Location, http.MethodPost, path, form, nil)
+	}
+	return nil
+}
+
+// Kick ends all of username's sessions. Needs a moderator account.
+func (c *Client) Kick(ctx context.Context, username string) error {
+	return c.endSession(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(username)+"/kick")
+}
+
+// Match finds the user's current match, e.g. after hosting and being joined.
+// Errors with CodeNotPlaying until someone joins.
+func (c *Client) Match(ctx context.Context) (string, error) {
+	var body struct {
+		GameID   string `json:"game_id"`
+		Location string `json:"location"`
+	}
+	if err := c.do(ctx, c.BaseURL, http.MethodGet, "/game/match", c.authForm(), &body); err != nil {
+		return "", err
+	}
+	c.matchID = body.GameID
+	c.matchURL = body.Location
+	return body.GameID, nil
+}
+
+// GameState fetches the current match as the user sees it
+func (c *Client) GameState(ctx context.Context) (*GameState, error) {
+	if c.matchID == "" {
+		return nil, ErrNoMatch
+	}
+	form := c.authForm()
+	form.Set("match_id", c.matchID)
+
+	gs := &GameState{}
+	if err := c.game(ctx, http.MethodGet, "/game/play", form, gs); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// Fire at (x, y) on the enemy board
+func (c *Client) Fire(ctx context.Context, x, y int) (*MoveResult, error) {
+	if c.matchID == "" {
+		return nil, ErrNoMatch
+	}
+	form := c.authForm()
+	form.Set("match_id", c.matchID)
+	form.Set("x", strconv.Itoa(x))
+	form.Set("y", strconv.Itoa(y))
+
+	res := &MoveResult{}
+	if err := c.game(ctx, http.MethodPost, "/game/play", form, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// game sends a request to the node hosting the match. If that node is gone or
+// draining the match has been handed to another one, so ask where and retry once.
+func (c *Client) game(ctx context.Context, method, path string, form url.Values, out any) error {
+	err := c.do(ctx, c.MatchURL(), method, path, form, out)
+	if err == nil || c.matchURL == "" {
+		return err
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.Code != CodeNodeDraining && apiErr.Code != CodeMatchNotLoaded {
+		return err
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+
+	if _, matchErr := c.Match(ctx); matchErr != nil {
+		return err
+	}
+	return c.do(ctx, c.MatchURL(), method, path, form, out)
+}
+
+// authForm is the form for requests that need a session, the token itself
+// goes in the Authorization header
+func (c *Client) authForm() url.Values {
+	return url.Values{}
+}
+
+// do sends form to base+apiPrefix+path, in the query string for GETs, and
+// decodes the body into out. A 302 isn't an error, out gets its body and the
+// caller picks "location" out of it.
+func (c *Client) do(ctx context.Context, base, method, path string, form url.Values, out any) error {
+	target, body := base+apiPrefix+path, ""
+	if method == http.MethodGet {
+		if len(form) > 0 {
+			sep := "?"
+			if strings.Contains(target, "?") {
+				sep = "&"
+			}
+			target += sep + form.Encode()
+		}
+	} else {
+		body = form.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := http.DefaultClient
+	if c.HTTPClient != nil {
+		hc = c.HTTPClient
+	}
+	noFollow := *hc
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := noFollow.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if renewed := resp.Header.Get("Session-Token"); renewed != "" {
+		c.Token = renewed
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(raw, apiErr); err != nil || apiErr.Code == "" {
+			apiErr.Code = CodeUnknown
+			apiErr.Message = strings.TrimSpace(string(raw))
+		}
+		return apiErr
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("decoding %s %s: %w", method, path, err)
+		}
+	}
+	return nil
+}
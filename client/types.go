@@ -0,0 +1,216 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PlayerType mirrors the server's, host or guest
+type PlayerType int
+
+const (
+	Host PlayerType = iota
+	Guest
+	NoneWinner
+)
+
+// Direction of a ship
+type Direction int
+
+const (
+	Horizontal Direction = iota
+	Vertical
+)
+
+// Ship occupies Startx..Endx, Starty..Endy inclusive
+type Ship struct {
+	Startx int       `json:"startx"`
+	Starty int       `json:"starty"`
+	Endx   int       `json:"endx"`
+	Endy   int       `json:"endy"`
+	Dir    Direction `json:"direction"`
+	Alive  bool      `json:"alive"`
+}
+
+// Board is a player's own board
+type Board struct {
+	W     int     `json:"width"`
+	H     int     `json:"height"`
+	Ships []*Ship `json:"ships"`
+}
+
+// Move is one shot. Moves alternate, the first one is FirstPlayer's.
+type Move struct {
+	X   int  `json:"x"`
+	Y   int  `json:"y"`
+	Hit bool `json:"hit"`
+}
+
+// GameState is the server's CensoredGameState, only the user's own board
+type GameState struct {
+	You         PlayerType `json:"player"`
+	Board       *Board     `json:"board"`
+	FirstPlayer PlayerType `json:"firstPlayer"`
+	Moves       []*Move    `json:"moves"`
+	Winner      PlayerType `json:"winner"`
+}
+
+// Turn is whose shot it is
+func (gs *GameState) Turn() PlayerType {
+	return gs.Shooter(len(gs.Moves))
+}
+
+// Shooter of the i-th move
+func (gs *GameState) Shooter(i int) PlayerType {
+	if i%2 == 0 {
+		return gs.FirstPlayer
+	}
+	if gs.FirstPlayer == Host {
+		return Guest
+	}
+	return Host
+}
+
+// MoveResult is the answer to Fire
+type MoveResult struct {
+	Message string `json:"message"`
+	Hit     bool   `json:"hit"`
+	Win     bool   `json:"win"`
+}
+
+// Rules is a variant a host picked
+type Rules struct {
+	Name        string `json:"name"`
+	ShipLengths []int  `json:"shipLengths"`
+}
+
+// LobbyEntry is a user waiting to be joined. BoardSize 0 means random.
+type LobbyEntry struct {
+	Username       string    `json:"username"`
+	Rules          Rules     `json:"rules"`
+	BoardSize      int       `json:"boardSize"`
+	HostingSince   time.Time `json:"hostingSince"`
+	WaitingSeconds int       `json:"waitingSeconds"`
+}
+
+// Session is one of an account's sessions
+type Session struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	LastAccess time.Time `json:"lastAccess"`
+	Current    bool      `json:"current"`
+}
+
+// AdminMatch is a live match as operators see it
+type AdminMatch struct {
+	MatchID    string    `json:"matchID"`
+	Host       string    `json:"host"`
+	Guest      string    `json:"guest"`
+	Turn       string    `json:"turn"`
+	Moves      int       `json:"moves"`
+	Over       bool      `json:"over"`
+	StartedAt  time.Time `json:"startedAt"`
+	AgeSeconds int       `json:"ageSeconds"`
+}
+
+// Rating is a player's Glicko-2 rating. History is newest first.
+type Rating struct {
+	Username   string  `json:"username"`
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+	Games      int     `json:"games"`
+	History    []struct {
+		GameID    string    `json:"game_id"`
+		Rating    float64   `json:"rating"`
+		Deviation float64   `json:"deviation"`
+		At        time.Time `json:"at"`
+	} `json:"history"`
+}
+
+// ErrNoMatch is returned by game calls before JoinMatch or Match found one
+var ErrNoMatch = errors.New("client: not in a match")
+
+// Error codes returned by the server, see api_errors.go there
+const (
+	CodeUnknown          = "unknown"
+	CodeSQL              = "sql_error"
+	CodeInternal         = "internal_error"
+	CodeInternalComms    = "internal_communication_error"
+	CodeNodeDraining     = "node_draining"
+	CodeMissingUsername  = "missing_username"
+	CodeUsernameTooLong  = "username_too_long"
+	CodeUsernameTooShort = "username_too_short"
+	CodeBadUsername      = "bad_username"
+	CodeUsernameReserved = "username_reserved"
+	CodeUsernameBlocked  = "username_not_allowed"
+	CodeUsernameTaken    = "username_taken"
+	CodeMissingToken     = "missing_token"
+	CodeMalformedToken   = "malformed_token"
+	CodeInvalidToken     = "invalid_token"
+	CodeExpiredToken     = "expired_token"
+	CodeMissingPassword  = "missing_password"
+	CodeBadPasswordLen   = "bad_password_length"
+	CodeBadLogin         = "bad_login"
+	CodeNotAccount       = "not_account"
+	CodeForbidden        = "forbidden"
+	CodeUnknownRole      = "unknown_role"
+	CodeAccountNotFound  = "account_not_found"
+	CodeUserNotFound     = "user_not_found"
+	CodeBadOutcome       = "bad_outcome"
+	CodeSessionNotFound  = "session_not_found"
+	CodeUserNotIdle      = "user_not_idle"
+	CodeUserNotHosting   = "user_not_hosting"
+	CodeNoHosts          = "no_hosts"
+	CodeNotPlaying       = "not_playing"
+	CodeUnknownRules     = "unknown_rules"
+	CodeBadBoardSize     = "bad_board_size"
+	CodeHostGone         = "host_gone"
+	CodeJoinSelf         = "join_self"
+	CodeBadPrivate       = "bad_private"
+	CodeInviteNotFound   = "invite_not_found"
+	CodeNotRated         = "not_rated"
+	CodeNotQueued        = "not_queued"
+	CodeGameState        = "game_state_error"
+	CodeMissingMatchID   = "missing_match_id"
+	CodeMalformedMatchID = "malformed_match_id"
+	CodeMatchNotLoaded   = "match_not_loaded"
+	CodeNotInMatch       = "not_in_match"
+	CodeMissingCoord     = "missing_coordinate"
+	CodeInvalidCoord     = "invalid_coordinate"
+	CodeNotYourTurn      = "not_your_turn"
+	CodeShotOutOfBounds  = "shot_out_of_bounds"
+	CodeMatchOver        = "match_over"
+	CodeGameNotFound     = "game_not_found"
+	CodeBadMoveNumber    = "bad_move_number"
+	CodeCorruptGame      = "corrupt_game"
+	CodeMatchNotOver     = "match_not_over"
+	CodeMissingNotation  = "missing_notation"
+	CodeInvalidNotation  = "invalid_notation"
+	CodeMissingGameID    = "missing_game_id"
+	CodeBadMatchImport   = "bad_match_import"
+	CodeMissingSignature = "missing_signature"
+	CodeBadSignature     = "bad_signature"
+)
+
+// Error is an error response from the server
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("battlegov3: %d %s: %s (%s)", e.StatusCode, e.Code, e.Message, e.Detail)
+	}
+	return fmt.Sprintf("battlegov3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsCode reports whether err is a server error with the given code
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+)
+
+// stringLiterals is every string literal passed to fn in file, or every
+// string constant in it when fn is empty
+func stringLiterals(t *testing.T, file, fn string) map[string]bool {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+	add := func(e ast.Expr) {
+		if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			s, _ := strconv.Unquote(lit.Value)
+			found[s] = true
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if id, ok := n.Fun.(*ast.Ident); ok && fn != "" && id.Name == fn && len(n.Args) > 1 {
+				add(n.Args[1])
+			}
+		case *ast.GenDecl:
+			if n.Tok == token.CONST && fn == "" {
+				for _, spec := range n.Specs {
+					for _, v := range spec.(*ast.ValueSpec).Values {
+						add(v)
+					}
+				}
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// every code the server can answer with has a constant here
+func TestCodesMatchServer(t *testing.T) {
+	server := stringLiterals(t, "../api_errors.go", "newAPIError")
+	ours := stringLiterals(t, "types.go", "")
+	if len(server) == 0 {
+		t.Fatal("found no codes in api_errors.go")
+	}
+	for code := range server {
+		if !ours[code] {
+			t.Errorf("no constant for %q", code)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"nest/battlegov3/client"
+)
+
+// cells, both grids
+const (
+	cellWater = '.'
+	cellShip  = 'O'
+	cellSunk  = '#'
+	cellHit   = 'X'
+	cellMiss  = '~'
+)
+
+// parseCoord turns "B7" into (1, 6). Columns are letters, rows start at 1.
+func parseCoord(s string, w, h int) (int, int, error) {
+	s = strings.ToUpper(s)
+	if len(s) < 2 || !unicode.IsLetter(rune(s[0])) {
+		return 0, 0, fmt.Errorf("%q isn't a coordinate, try something like B7", s)
+	}
+
+	x := int(s[0] - 'A')
+	y, err := strconv.Atoi(s[1:])
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q isn't a coordinate, try something like B7", s)
+	}
+	y--
+
+	if x < 0 || x >= w || y < 0 || y >= h {
+		return 0, 0, fmt.Errorf("%s is off the board, columns A-%c and rows 1-%d", s, 'A'+w-1, h)
+	}
+	return x, y, nil
+}
+
+// render prints your fleet and what you know of the enemy's side by side.
+// Both boards are the same size.
+func render(out io.Writer, gs *client.GameState) {
+	b := gs.Board
+	own := grid(b.W, b.H)
+	enemy := grid(b.W, b.H)
+
+	for _, s := range b.Ships {
+		mark := cellShip
+		if !s.Alive {
+			mark = cellSunk
+		}
+		for x := s.Startx; x <= s.Endx; x++ {
+			for y := s.Starty; y <= s.Endy; y++ {
+				own[y][x] = mark
+			}
+		}
+	}
+
+	for i, m := range gs.Moves {
+		target := own
+		if gs.Shooter(i) == gs.You {
+			target = enemy
+		}
+		if m.Y < 0 || m.Y >= b.H || m.X < 0 || m.X >= b.W {
+			continue
+		}
+		switch {
+		case m.Hit && target[m.Y][m.X] != cellSunk:
+			target[m.Y][m.X] = cellHit
+		case !m.Hit:
+			target[m.Y][m.X] = cellMiss
+		}
+	}
+
+	header := "   "
+	for x := 0; x < b.W; x++ {
+		header += string(rune('A'+x)) + " "
+	}
+	fmt.Fprintf(out, "\n%-*s    %s\n", len(header), " your fleet", " enemy waters")
+	fmt.Fprintf(out, "%s    %s\n", header, header)
+	for y := 0; y < b.H; y++ {
+		fmt.Fprintf(out, "%2d %s    %2d %s\n", y+1, row(own[y]), y+1, row(enemy[y]))
+	}
+
+	if gs.Turn() == gs.You {
+		fmt.Fprintln(out, "\nyour turn")
+	}
+}
+
+func grid(w, h int) [][]rune {
+	g := make([][]rune, h)
+	for y := range g {
+		g[y] = []rune(strings.Repeat(string(cellWater), w))
+	}
+	return g
+}
+
+func row(cells []rune) string {
+	var sb strings.Builder
+	for _, c := range cells {
+		sb.WriteRune(c)
+		sb.WriteRune(' ')
+	}
+	return sb.String()
+}
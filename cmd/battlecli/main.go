@@ -0,0 +1,199 @@
+// battlecli plays a game against a battlegov3 deployment from the terminal.
+//
+//	battlecli -server http://localhost:8080 -name alice -host
+//	battlecli -server http://localhost:8080 -name bob -join
+//
+// Shots are typed as column letter then row number, e.g. "B7".
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"nest/battlegov3/client"
+)
+
+const pollInterval = 2 * time.Second
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "base URL of any node")
+	name := flag.String("name", "", "username to register, 3 to 16 characters")
+	password := flag.String("password", "", "log in to the account -name instead of playing as a guest")
+	host := flag.Bool("host", false, "host a match and wait for someone to join")
+	join := flag.Bool("join", false, "queue for an opponent of similar rating")
+	flag.Parse()
+
+	if *name == "" || *host == *join {
+		fmt.Fprintln(os.Stderr, "need -name and exactly one of -host or -join")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := client.New(*server)
+	if *password != "" {
+		if err := c.Login(ctx, *name, *password); err != nil {
+			log.Fatalf("logging in as %q: %v", *name, err)
+		}
+		fmt.Printf("logged in as %s\n", *name)
+	} else {
+		if err := c.Register(ctx, *name); err != nil {
+			log.Fatalf("registering %q: %v", *name, err)
+		}
+		fmt.Printf("registered as %s\n", *name)
+	}
+
+	if *host {
+		if err := c.HostMatch(ctx); err != nil {
+			log.Fatalf("hosting: %v", err)
+		}
+		fmt.Println("hosting, waiting for someone to join...")
+		if err := waitForMatch(ctx, c, c.UnhostMatch); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		id, err := c.JoinMatch(ctx)
+		if err != nil {
+			log.Fatalf("joining: %v", err)
+		}
+		if id == "" {
+			fmt.Println("queued, waiting for an opponent...")
+			if err := waitForMatch(ctx, c, c.LeaveQueue); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	fmt.Printf("in match %s on %s\n", c.MatchID(), c.MatchURL())
+
+	if err := play(ctx, c, bufio.NewReader(os.Stdin)); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+}
+
+// waitForMatch polls until someone pairs with us. leave takes us out of the
+// pool when we give up.
+func waitForMatch(ctx context.Context, c *client.Client, leave func(context.Context) error) error {
+	for {
+		_, err := c.Match(ctx)
+		if err == nil {
+			return nil
+		}
+		if !client.IsCode(err, client.CodeNotPlaying) {
+			// don't leave a dead player in the pool
+			leave(context.Background())
+			return fmt.Errorf("waiting for an opponent: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			leave(context.Background())
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func play(ctx context.Context, c *client.Client, in *bufio.Reader) error {
+	waiting := false
+	for {
+		gs, err := c.GameState(ctx)
+		if err != nil {
+			return fmt.Errorf("fetching game state: %w", err)
+		}
+
+		if gs.Winner != client.NoneWinner {
+			render(os.Stdout, gs)
+			switch {
+			case gs.Winner == gs.You:
+				fmt.Println("the other player gave up, you won")
+			case allSunk(gs.Board):
+				fmt.Println("all your ships are sunk, you lost")
+			default:
+				fmt.Println("the match was ended, you lost")
+			}
+			return nil
+		}
+
+		if gs.Turn() != gs.You {
+			if !waiting {
+				render(os.Stdout, gs)
+				fmt.Println("waiting for the other player...")
+				waiting = true
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		waiting = false
+
+		render(os.Stdout, gs)
+		x, y, err := prompt(in, gs.Board)
+		if err != nil {
+			return err
+		}
+
+		res, err := c.Fire(ctx, x, y)
+		if err != nil {
+			var apiErr *client.Error
+			if errors.As(err, &apiErr) && apiErr.StatusCode < 500 {
+				fmt.Println(apiErr.Message)
+				continue
+			}
+			return fmt.Errorf("firing: %w", err)
+		}
+
+		if res.Hit {
+			fmt.Println("hit!")
+		} else {
+			fmt.Println("miss")
+		}
+		if res.Win {
+			fmt.Println("every enemy ship is sunk, you won")
+			return nil
+		}
+	}
+}
+
+// prompt reads shots until one parses and is on the board
+func prompt(in *bufio.Reader, b *client.Board) (int, int, error) {
+	for {
+		fmt.Print("fire> ")
+		line, err := in.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, 0, errors.New("stdin closed")
+			}
+			return 0, 0, err
+		}
+
+		x, y, err := parseCoord(strings.TrimSpace(line), b.W, b.H)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		return x, y, nil
+	}
+}
+
+func allSunk(b *client.Board) bool {
+	for _, s := range b.Ships {
+		if s.Alive {
+			return false
+		}
+	}
+	return len(b.Ships) > 0
+}
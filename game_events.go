@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EventKind is what happened in a game
+type EventKind string
+
+// A game is the sequence of these, GameState is whatever they add up to.
+// Player on an event is whoever did it: the owner for ships placed, the
+// shooter for shots and sinkings, the winner for game over, the quitter for
+// forfeit. Created has nobody.
+const (
+	EventCreated     EventKind = "created"
+	EventShipsPlaced EventKind = "ships_placed"
+	EventShotFired   EventKind = "shot_fired"
+	EventShipSunk    EventKind = "ship_sunk"
+	EventGameOver    EventKind = "game_over"
+	EventForfeit     EventKind = "forfeit"
+)
+
+// Event is one entry in a game's log. Only the fields for its Kind are set.
+type Event struct {
+	Seq    int        `json:"seq"`
+	Kind   EventKind  `json:"kind"`
+	Player PlayerType `json:"player"`
+	At     time.Time  `json:"at"`
+
+	// created
+	Width  int        `json:"width,omitempty"`
+	Height int        `json:"height,omitempty"`
+	First  PlayerType `json:"first,omitempty"`
+	Seed   int64      `json:"seed,omitempty"`
+	Rules  string     `json:"rules,omitempty"`
+
+	// ships placed
+	Ships []*Ship `json:"ships,omitempty"`
+
+	// shot fired
+	X   int  `json:"x,omitempty"`
+	Y   int  `json:"y,omitempty"`
+	Hit bool `json:"hit,omitempty"`
+
+	// ship sunk, index into the target's ships
+	Ship int `json:"ship,omitempty"`
+}
+
+var ErrBadEvent = errors.New("event doesn't follow from the game so far")
+
+// stamp numbers and dates events that are about to be appended to g
+func (g *GameState) stamp(evs []Event) []Event {
+	now := time.Now().UTC()
+	for i := range evs {
+		evs[i].Seq = len(g.events) + i
+		evs[i].At = now
+	}
+	return evs
+}
+
+// applyAll applies evs in order, stopping at the first bad one
+func (g *GameState) applyAll(evs []Event) error {
+	for _, ev := range evs {
+		if err := g.apply(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply is the only thing that changes a GameState. It checks ev against the
+// rules, so a log that doesn't add up fails to replay.
+func (g *GameState) apply(ev Event) error {
+	if ev.Seq != len(g.events) {
+		return fmt.Errorf("%w: event %d is numbered %d", ErrBadEvent, len(g.events), ev.Seq)
+	}
+	if ev.Kind != EventCreated && g.boardHost == nil {
+		return fmt.Errorf("%w: %s before created", ErrBadEvent, ev.Kind)
+	}
+
+	switch ev.Kind {
+	case EventCreated:
+		if g.boardHost != nil {
+			return fmt.Errorf("%w: created twice", ErrBadEvent)
+		}
+		g.boardHost, _ = newBoard(ev.Width, ev.Height)
+		g.boardGuest, _ = newBoard(ev.Width, ev.Height)
+		g.evens = ev.First
+		g.moves = []*Move{}
+		g.winner = NoneWinner
+
+	case EventShipsPlaced:
+		board := g.getOwnBoard(ev.Player)
+		if board == nil || len(board.Ships) > 0 {
+			return fmt.Errorf("%w: ships placed twice or for nobody", ErrBadEvent)
+		}
+		// copies, the event stays as it was
+		ships := make([]*Ship, len(ev.Ships))
+		for i, s := range ev.Ships {
+			cp := *s
+			ships[i] = &cp
+		}
+		placed, err := newBoard(board.W, board.H, ships...)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBadEvent, err)
+		}
+		*board = *placed
+
+	case EventShotFired:
+		if err := g.checkShot(ev.X, ev.Y, ev.Player); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadEvent, err)
+		}
+		if _, hit := g.getTargetBoard(ev.Player).shipAtCoords(ev.X, ev.Y); hit != ev.Hit {
+			return fmt.Errorf("%w: shot at %d,%d says hit=%t", ErrBadEvent, ev.X, ev.Y, ev.Hit)
+		}
+		g.moves = append(g.moves, &Move{ev.X, ev.Y, ev.Hit})
+
+	case EventShipSunk:
+		target := g.getTargetBoard(ev.Player)
+		if ev.Ship < 0 || ev.Ship >= len(target.Ships) || !target.Ships[ev.Ship].Alive {
+			return fmt.Errorf("%w: can't sink ship %d", ErrBadEvent, ev.Ship)
+		}
+		target.Ships[ev.Ship].Alive = false
+
+	case EventGameOver:
+		if g.winner != NoneWinner || aliveShips(g.getTargetBoard(ev.Player)) > 0 {
+			return fmt.Errorf("%w: %d can't have won", ErrBadEvent, ev.Player)
+		}
+		g.winner = ev.Player
+
+	case EventForfeit:
+		if g.winner != NoneWinner || g.getOwnBoard(ev.Player) == nil {
+			return fmt.Errorf("%w: can't forfeit", ErrBadEvent)
+		}
+		g.winner = opponent(ev.Player)
+
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrBadEvent, ev.Kind)
+	}
+
+	g.events = append(g.events, ev)
+	return nil
+}
+
+// winnerOf is who evs make the winner, NoneWinner if they don't end the game
+func winnerOf(evs []Event) PlayerType {
+	for _, ev := range evs {
+		switch ev.Kind {
+		case EventGameOver:
+			return ev.Player
+		case EventForfeit:
+			return opponent(ev.Player)
+		}
+	}
+	return NoneWinner
+}
+
+// replay builds a game from its log as it stood after the first move shots,
+// and whatever those shots caused. A negative move means the whole log.
+func replay(events []Event, move int) (*GameState, error) {
+	gs := &GameState{winner: NoneWinner}
+	shots := 0
+	for _, ev := range events {
+		if ev.Kind == EventShotFired {
+			if move >= 0 && shots == move {
+				break
+			}
+			shots++
+		}
+		if err := gs.apply(ev); err != nil {
+			return nil, err
+		}
+	}
+	if gs.boardHost == nil {
+		return nil, fmt.Errorf("%w: empty log", ErrBadEvent)
+	}
+	return gs, nil
+}
+
+// MarshalJSON sends the log, for handing matches to another node. Players
+// never see this, they get toCensored.
+func (g *GameState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.events)
+}
+
+func (g *GameState) UnmarshalJSON(b []byte) error {
+	var evs []Event
+	if err := json.Unmarshal(b, &evs); err != nil {
+		return err
+	}
+	gs, err := replay(evs, -1)
+	if err != nil {
+		return err
+	}
+	*g = *gs
+	return nil
+}
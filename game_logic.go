@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"math/rand"
+	"strings"
 )
 
 /*
@@ -48,6 +49,14 @@ const (
 
 var players = []PlayerType{Host, Guest}
 
+// errors returned by the game rules, mapped to API errors in game_requests.go
+var (
+	ErrIncorrectOrder = errors.New("incorrect player order")
+	ErrHitOutOfBounds = errors.New("hit is out of bounds")
+	ErrGameOver       = errors.New("game is over")
+	ErrFleetDoesntFit = errors.New("fleet doesn't fit on the board")
+)
+
 // Ship in Battleship
 type Ship struct {
 	Startx int       `json:"startx"`
@@ -72,41 +81,66 @@ type Move struct {
 	Hit bool `json:"hit"`
 }
 
-// GameState represents a game
+// GameState represents a game. It's derived from events, see apply,
+// so don't set fields directly.
 type GameState struct {
 	boardHost  *Board
 	boardGuest *Board
 	evens      PlayerType
 	moves      []*Move
+	winner     PlayerType
+	events     []Event
 }
 
 // implement presentablegamestate struct, as in https://github.com/gin-gonic/gin/issues/715#issuecomment-381302094
 // implement GS.toPresentable which takes Player and returns Presentable object with only that player's board
 // add json bindings to ship, board, move
 
-func newGameState() (*GameState, error) {
-	gs := &GameState{}
+func newGameState(rules Rules, size int) (*GameState, error) {
+	return newGameStateFromSeed(rand.Int63(), rules, size)
+}
 
-	dim := rand.Intn(5) + 8
+// newGameStateFromSeed deals the same game every time for the same seed,
+// rules and size. Size 0 is random.
+func newGameStateFromSeed(seed int64, rules Rules, size int) (*GameState, error) {
+	gs := &GameState{winner: NoneWinner}
+	rng := rand.New(rand.NewSource(seed))
+
+	// drawn either way so a seed deals the same fleets at the size it picked
+	dim := rng.Intn(maxBoardSize-minBoardSize+1) + minBoardSize
+	if size != 0 {
+		dim = size
+	}
 
-	boardHost, err := newBoardFromRandom(dim)
+	boardHost, err := newBoardFromRandom(rng, dim, rules.ShipLengths)
 	if err != nil {
 		return nil, err
 	}
-	gs.boardHost = boardHost
 
-	boardGuest, err := newBoardFromRandom(dim)
+	boardGuest, err := newBoardFromRandom(rng, dim, rules.ShipLengths)
 	if err != nil {
 		return nil, err
 	}
-	gs.boardGuest = boardGuest
 
-	gs.evens = players[rand.Intn(len(players))]
-	gs.moves = []*Move{}
+	err = gs.applyAll(gs.stamp([]Event{
+		{Kind: EventCreated, Player: NoneWinner, Width: dim, Height: dim, First: players[rng.Intn(len(players))], Seed: seed, Rules: rules.Name},
+		{Kind: EventShipsPlaced, Player: Host, Ships: boardHost.Ships},
+		{Kind: EventShipsPlaced, Player: Guest, Ships: boardGuest.Ships},
+	}))
+	if err != nil {
+		return nil, err
+	}
 
 	return gs, nil
 }
 
+func opponent(p PlayerType) PlayerType {
+	if p == Host {
+		return Guest
+	}
+	return Host
+}
+
 func (g *GameState) getTargetBoard(p PlayerType) *Board {
 	if p == Host {
 		return g.boardGuest
@@ -114,45 +148,80 @@ func (g *GameState) getTargetBoard(p PlayerType) *Board {
 	return g.boardHost
 }
 
-func (g *GameState) tryHitEnemy(x, y int, p PlayerType) (bool, error) {
+// getOwnBoard is nil for anyone but host and guest
+func (g *GameState) getOwnBoard(p PlayerType) *Board {
+	switch p {
+	case Host:
+		return g.boardHost
+	case Guest:
+		return g.boardGuest
+	}
+	return nil
+}
+
+// checkShot tells whether p may fire at x, y without firing
+func (g *GameState) checkShot(x, y int, p PlayerType) error {
+	if g.winner != NoneWinner {
+		return ErrGameOver
+	}
+
 	if mod := len(g.moves) % 2; (g.evens == p && mod == 1) || (g.evens != p && mod == 0) {
-		return false, errors.New("incorrect player order")
+		return ErrIncorrectOrder
 	}
 
 	targetBoard := g.getTargetBoard(p)
 
-	if x >= targetBoard.W || y >= targetBoard.H {
-		return false, errors.New("hit is out of bounds")
+	if x < 0 || y < 0 || x >= targetBoard.W || y >= targetBoard.H {
+		return ErrHitOutOfBounds
 	}
 
-	move := &Move{x, y, false}
-	ship, exists := targetBoard.shipAtCoords(x, y)
+	return nil
+}
+
+// fire returns the events for p shooting at x, y without applying them.
+// Any hit sinks the ship, sinking the last one wins.
+func (g *GameState) fire(x, y int, p PlayerType) ([]Event, error) {
+	if err := g.checkShot(x, y, p); err != nil {
+		return nil, err
+	}
+
+	targetBoard := g.getTargetBoard(p)
+	evs := []Event{{Kind: EventShotFired, Player: p, X: x, Y: y}}
+
+	i, exists := targetBoard.shipIndexAt(x, y)
 	if exists {
-		ship.Alive = false
-		move.Hit = true
+		evs[0].Hit = true
+		if targetBoard.Ships[i].Alive {
+			evs = append(evs, Event{Kind: EventShipSunk, Player: p, Ship: i})
+			if aliveShips(targetBoard) == 1 {
+				evs = append(evs, Event{Kind: EventGameOver, Player: p})
+			}
+		}
 	}
 
-	g.moves = append(g.moves, move)
-	return move.Hit, nil
+	return g.stamp(evs), nil
 }
 
-func (g *GameState) anyAliveEnemy(p PlayerType) bool {
-	targetBoard := g.getTargetBoard(p)
+// forfeit returns the event for p giving up
+func (g *GameState) forfeit(p PlayerType) ([]Event, error) {
+	if g.winner != NoneWinner {
+		return nil, ErrGameOver
+	}
+	return g.stamp([]Event{{Kind: EventForfeit, Player: p}}), nil
+}
 
-	for _, ship := range targetBoard.Ships {
+func aliveShips(board *Board) int {
+	alive := 0
+	for _, ship := range board.Ships {
 		if ship.Alive {
-			return true
+			alive++
 		}
 	}
-
-	return false
+	return alive
 }
 
-// make function to see if any ships are alive
-// call function in makemove API command whatever after tryHit
-
 func (g *GameState) toCensored(p PlayerType) *CensoredGameState {
-	cgs := &CensoredGameState{}
+	cgs := &CensoredGameState{Player: p}
 
 	switch p {
 	case Host:
@@ -163,10 +232,74 @@ func (g *GameState) toCensored(p PlayerType) *CensoredGameState {
 
 	cgs.Evens = g.evens
 	cgs.Moves = g.moves
+	cgs.Winner = g.winner
 
 	return cgs
 }
 
+// toFull shows everything, both boards included. Not for live games.
+func (g *GameState) toFull() *FullGameState {
+	return &FullGameState{
+		HostBoard:  g.boardHost,
+		GuestBoard: g.boardGuest,
+		Evens:      g.evens,
+		Moves:      g.moves,
+		Winner:     g.winner,
+	}
+}
+
+// toSpectator shows both boards the way their opponents see them, safe to
+// show anyone while the game is on
+func (g *GameState) toSpectator() *SpectatorGameState {
+	shotsAt := map[PlayerType][]*Move{}
+	shooter := g.evens
+	for _, m := range g.moves {
+		shotsAt[opponent(shooter)] = append(shotsAt[opponent(shooter)], m)
+		shooter = opponent(shooter)
+	}
+
+	return &SpectatorGameState{
+		HostFog:  fogOfWar(g.boardHost, shotsAt[Host]),
+		GuestFog: fogOfWar(g.boardGuest, shotsAt[Guest]),
+		Evens:    g.evens,
+		Moves:    g.moves,
+		Winner:   g.winner,
+	}
+}
+
+// fogOfWar draws board as its opponent knows it, one string per row:
+// '.' not shot at, 'o' miss, 'x' hit, '#' part of a sunk ship
+func fogOfWar(board *Board, shots []*Move) []string {
+	grid := make([][]byte, board.H)
+	for y := range grid {
+		grid[y] = []byte(strings.Repeat(".", board.W))
+	}
+
+	for _, m := range shots {
+		grid[m.Y][m.X] = 'o'
+		if m.Hit {
+			grid[m.Y][m.X] = 'x'
+		}
+	}
+
+	for _, ship := range board.Ships {
+		if ship.Alive {
+			continue
+		}
+		for x := ship.Startx; x <= ship.Endx && x < board.W; x++ {
+			for y := ship.Starty; y <= ship.Endy && y < board.H; y++ {
+				grid[y][x] = '#'
+			}
+		}
+	}
+
+	rows := make([]string, board.H)
+	for y := range grid {
+		rows[y] = string(grid[y])
+	}
+	return rows
+}
+
 func getEndCoords(startx, starty, boardx, boardy, length int, dir Direction) (int, int, error) {
 	outOfBoundsError := errors.New("ship is out of bounds")
 
@@ -215,29 +348,48 @@ func (board *Board) addShip(ship *Ship) error {
 }
 
 func (board *Board) shipAtCoords(x, y int) (*Ship, bool) {
-	if (x >= board.W) || (y >= board.H) {
+	i, exists := board.shipIndexAt(x, y)
+	if !exists {
 		return nil, false
 	}
+	return board.Ships[i], true
+}
 
-	for _, ship := range board.Ships {
+func (board *Board) shipIndexAt(x, y int) (int, bool) {
+	if (x >= board.W) || (y >= board.H) {
+		return 0, false
+	}
+
+	for i, ship := range board.Ships {
 		if (x >= ship.Startx) && (x <= ship.Endx) && (y >= ship.Starty) && (y <= ship.Endy) {
-			return ship, true
+			return i, true
 		}
 	}
-	return nil, false
+	return 0, false
 }
 
-func newBoardFromRandom(dim int) (*Board, error) {
+// newBoardFromRandom places a ship of each length, lengths in squares
+// maxPlacementAttempts bounds how many random spots newBoardFromRandom tries
+// for a whole fleet
+const maxPlacementAttempts = 1000
+
+func newBoardFromRandom(rng *rand.Rand, dim int, lengths []int) (*Board, error) {
 	board, _ := newBoard(dim, dim)
 
-	for i := 0; i < 3; i++ {
-		startx := rand.Intn(dim)
-		starty := rand.Intn(dim)
+	attempts := 0
+	for i := 0; i < len(lengths); i++ {
+		// a fleet that doesn't fit would never stop trying
+		if attempts++; attempts > maxPlacementAttempts {
+			return nil, ErrFleetDoesntFit
+		}
+
+		startx := rng.Intn(dim)
+		starty := rng.Intn(dim)
 
-		direction := directions[rand.Intn(len(directions))]
+		direction := directions[rng.Intn(len(directions))]
 
-		// maybe randomise ship length
-		endx, endy, err := getEndCoords(startx, starty, board.W, board.H, 3, direction)
+		// getEndCoords wants how far the end is from the start
+		endx, endy, err := getEndCoords(startx, starty, board.W, board.H, lengths[i]-1, direction)
 		if err != nil {
 			i--
 			continue
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -15,9 +17,20 @@ import (
 // CensoredGameState contains all information in gameState,
 // but only one board. Use gameState.toCensored()
 type CensoredGameState struct {
-	Board *Board     `json:"board"`
-	Evens PlayerType `json:"firstPlayer"`
-	Moves []*Move    `json:"moves"`
+	Player PlayerType `json:"player"`
+	Board  *Board     `json:"board"`
+	Evens  PlayerType `json:"firstPlayer"`
+	Moves  []*Move    `json:"moves"`
+	Winner PlayerType `json:"winner"`
+}
+
+// FullGameState is a game with both boards showing. Use gameState.toFull()
+type FullGameState struct {
+	HostBoard  *Board     `json:"hostBoard"`
+	GuestBoard *Board     `json:"guestBoard"`
+	Evens      PlayerType `json:"firstPlayer"`
+	Moves      []*Move    `json:"moves"`
+	Winner     PlayerType `json:"winner"`
 }
 
 func (e *env) getMatch(c *gin.Context) {
@@ -25,37 +38,32 @@ func (e *env) getMatch(c *gin.Context) {
 
 	var matchID string
 	var hostAddr string
+	// user_status.game_id is the live match, finished ones hang around in
+	// games for a while
 	err := e.db.QueryRow(context.Background(), `
 		SELECT
-			g.game_id
+			g.game_id,
 			g.host_addr
 		FROM
-			games AS g
+			games AS g,
+			user_status AS us
 		WHERE
-			EXISTS (
-				SELECT
-					us.user_token
-				FROM
-					user_status AS us
-				WHERE
-					us.token = $1 AND
-					us.user_status = 'playing'
-			) AND
-			($1 = g.player_one OR
-			$1 = g.player_two)
-	`, userToken.String()).Scan(matchID, hostAddr)
+			us.game_id = g.game_id AND
+			us.user_token = $1 AND
+			us.user_status = 'playing'
+	`, userToken.String()).Scan(&matchID, &hostAddr)
 
 	if errors.Is(err, pgx.ErrNoRows) {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "User not in playing state."})
+		respondError(c, ErrNotPlaying)
 		return
 	}
 
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, sqlErrorMessage)
+		respondError(c, ErrSQL)
 		return
 	}
 
-	if hostAddr != webServerHost {
+	if hostAddr != e.cfg.AdvertiseAddr {
 		c.IndentedJSON(http.StatusFound, gin.H{"message": "redirect requests to host server", "location": "http://" + hostAddr, "game_id": matchID})
 		return
 	}
@@ -66,31 +74,32 @@ func (e *env) getMatch(c *gin.Context) {
 func (e *env) playAuth(c *gin.Context) {
 	userToken := c.MustGet("token").(uuid.UUID)
 
-	matchTokenString, exists := c.GetPostForm("match_id")
+	matchTokenString, exists := formOrQuery(c, "match_id")
 	if !exists || matchTokenString == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "no match token supplied"})
-		c.Abort()
+		abortWithError(c, ErrMissingMatchID)
 		return
 	}
 
 	matchTokenUUID, err := uuid.Parse(matchTokenString)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "match token not UUID"})
-		c.Abort()
+		abortWithError(c, ErrMalformedMatchID)
 		return
 	}
 
 	// TODO: turn this until before censoredGameState into own functions or middleware
 	var match *Match
 	matchUncast, ok := e.matches.Load(matchTokenUUID)
-	if !ok {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "could not retrieve match from memory"})
-		c.Abort()
-		return
+	if ok {
+		match = matchUncast.(*Match)
+	} else {
+		// ours but not in memory, we must have restarted
+		match, err = e.recoverMatch(matchTokenUUID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
 	}
 
-	match = matchUncast.(*Match)
-
 	var p PlayerType
 	switch userToken {
 	case match.HostToken:
@@ -98,8 +107,8 @@ func (e *env) playAuth(c *gin.Context) {
 	case match.GuestToken:
 		p = Guest
 	default:
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "player not in match"})
-		c.Abort()
+		abortWithError(c, ErrNotInMatch)
+		return
 	}
 
 	c.Set("match", match)
@@ -107,9 +116,29 @@ func (e *env) playAuth(c *gin.Context) {
 	c.Set("matchToken", matchTokenUUID)
 }
 
+func (e *env) recoverMatch(matchID uuid.UUID) (*Match, error) {
+	ours, err := e.hostedHere(context.Background(), matchID)
+	if err != nil {
+		return nil, ErrSQL
+	}
+	if !ours {
+		return nil, ErrMatchNotLoaded
+	}
+
+	match, err := e.restoreMatch(context.Background(), matchID)
+	if err != nil {
+		log.Printf("Could not recover match %s: %v\n", matchID, err)
+		return nil, ErrGameStateCreation
+	}
+	return match, nil
+}
+
 func (e *env) getGameState(c *gin.Context) {
 	match := c.MustGet("match").(*Match)
 	p := c.MustGet("playerType").(PlayerType)
+
+	match.mu.Lock()
+	defer match.mu.Unlock()
 	censoredGameState := match.GameState.toCensored(p)
 	c.IndentedJSON(http.StatusOK, *censoredGameState)
 }
@@ -120,69 +149,133 @@ func (e *env) postMove(c *gin.Context) {
 
 	xString, exists := c.GetPostForm("x")
 	if !exists {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "x coord not supplied"})
+		respondError(c, ErrMissingCoordinate.withDetail("x"))
 		return
 	}
 
 	yString, exists := c.GetPostForm("y")
 	if !exists {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "y coord not supplied"})
+		respondError(c, ErrMissingCoordinate.withDetail("y"))
 		return
 	}
 
 	x, err := strconv.Atoi(xString)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "x not an integer"})
+		respondError(c, ErrInvalidCoordinate.withDetail("x"))
 		return
 	}
 
 	y, err := strconv.Atoi(yString)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "y not an integer"})
+		respondError(c, ErrInvalidCoordinate.withDetail("y"))
+		return
+	}
+
+	match.mu.Lock()
+	defer match.mu.Unlock()
+
+	// a draining node may have sent it away while we waited
+	if match.movedTo != "" {
+		c.IndentedJSON(http.StatusFound, gin.H{"message": "redirect requests to host server", "location": "http://" + match.movedTo})
 		return
 	}
 
-	hit, err := match.GameState.tryHitEnemy(x, y, p)
+	evs, err := match.GameState.fire(x, y, p)
+	switch {
+	case errors.Is(err, ErrGameOver):
+		respondError(c, ErrMatchOver)
+		return
+	case errors.Is(err, ErrIncorrectOrder):
+		respondError(c, ErrNotYourTurn)
+		return
+	case errors.Is(err, ErrHitOutOfBounds):
+		respondError(c, ErrShotOutOfBounds.withDetail(fmt.Sprintf("x=%d y=%d", x, y)))
+		return
+	case err != nil:
+		respondError(c, err)
+		return
+	}
+
+	// on disk before in memory, so a crash can't lose a move we answered for
+	matchID := c.MustGet("matchToken").(uuid.UUID)
+	err = e.saveMove(context.Background(), matchID, match, evs)
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	err = match.GameState.applyAll(evs)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		log.Printf("Match %s: fire made events it can't apply: %v\n", matchID, err)
+		respondError(c, err)
 		return
 	}
 
-	if hit && !match.GameState.anyAliveEnemy(p) {
+	hit := evs[0].Hit
+	if match.GameState.winner == p {
 		c.IndentedJSON(http.StatusOK, gin.H{"message": "match complete, you won!!!", "hit": hit, "win": true})
-		go func(){
-			time.Sleep(10 * time.Minute)
-			e.matchCleanup(c.MustGet("matchToken").(uuid.UUID))
-			}()
+		e.scheduleCleanup(matchID)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, gin.H{"message": "move registered", "hit": hit, "win": false})
 }
 
+// finished matches stay in memory this long, so both players get to see how
+// it ended
+const finishedMatchTTL = 10 * time.Minute
+
+// scheduleCleanup cleans up a finished match after finishedMatchTTL
+func (e *env) scheduleCleanup(matchID uuid.UUID) {
+	time.AfterFunc(finishedMatchTTL, func() { e.matchCleanup(matchID) })
+}
+
 // reuse in forfeit
 // TODO: logging
 func (e *env) matchCleanup(matchID uuid.UUID) {
-	var match *Match
-	matchUncast, loaded := e.matches.LoadAndDelete(matchID)
-	if !loaded {
+	if _, loaded := e.matches.LoadAndDelete(matchID); !loaded {
 		return
 	}
-	match = matchUncast.(*Match)
-	
-	tx, _ := e.db.Begin(context.Background())
-	defer tx.Rollback(context.Background())
 
-	var hostwin bool
-	if match.Winner == Host {
-		hostwin = true
-	} else {
-		hostwin = false
+	// the result went in with the move that ended the game, see recordResult
+	_, err := e.db.Exec(context.Background(), "DELETE FROM games WHERE game_id = $1", matchID.String())
+	if err != nil {
+		log.Printf("Match %s: could not clean up: %v\n", matchID, err)
 	}
+}
 
-	tx.Exec(context.Background(), "INSERT INTO game_history (player, game_id, won) values ($1, $2, $3)", match.HostToken.String(), matchID.String(), hostwin)
-	tx.Exec(context.Background(), "INSERT INTO game_history (player, game_id, won) values ($1, $2, $3)", match.GuestToken.String(), matchID.String(), !hostwin)
-	tx.Exec(context.Background(), "DELETE FROM games WHERE game_id=$1", matchID.String())
+// recordResult writes a game won by winner to history and rates it, in the
+// transaction of the move that ended it. Players come from the games row, so
+// it doesn't matter if their sessions are gone by the time the match is
+// cleaned up. Guests aren't rated, they'd start over with every session.
+func (e *env) recordResult(ctx context.Context, tx pgx.Tx, matchID uuid.UUID, winner PlayerType) error {
+	var hostToken, guestToken string
+	var hostAccount, guestAccount *string
+	err := tx.QueryRow(ctx, "SELECT player_one, player_two, player_one_account, player_two_account FROM games WHERE game_id = $1", matchID.String()).Scan(&hostToken, &guestToken, &hostAccount, &guestAccount)
+	if err != nil {
+		return err
+	}
+
+	hostwin := winner == Host
+	_, err = tx.Exec(ctx, "INSERT INTO game_history (player, account_id, game_id, won) VALUES ($1, $2, $5, $6), ($3, $4, $5, NOT $6) ON CONFLICT DO NOTHING", hostToken, hostAccount, guestToken, guestAccount, matchID.String(), hostwin)
+	if err != nil {
+		return err
+	}
 
-	tx.Commit(context.Background())
+	// an account playing itself from two sessions has nothing to win
+	if hostAccount == nil || guestAccount == nil || *hostAccount == *guestAccount {
+		return nil
+	}
+	hostID, err := uuid.Parse(*hostAccount)
+	if err != nil {
+		return err
+	}
+	guestID, err := uuid.Parse(*guestAccount)
+	if err != nil {
+		return err
+	}
+	if hostwin {
+		return e.updateRatings(ctx, tx, matchID, hostID, guestID)
+	}
+	return e.updateRatings(ctx, tx, matchID, guestID, hostID)
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// A game on disk is its event log (game_events). Replaying it gives back the
+// GameState, see replay.
+
+// saveEvents appends evs to the game's log. Two writers racing for the same
+// seq can't both get in, so always save before applying.
+func (e *env) saveEvents(ctx context.Context, tx pgx.Tx, matchID uuid.UUID, evs []Event) error {
+	for _, ev := range evs {
+		raw, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO game_events (game_id, seq, kind, data, at) VALUES ($1, $2, $3, $4, $5)", matchID.String(), ev.Seq, ev.Kind, raw, ev.At)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveMove is saveEvents in its own transaction. If evs end the game the
+// result is recorded and both players are let go in the same transaction, so
+// a game can't end without either.
+func (e *env) saveMove(ctx context.Context, matchID uuid.UUID, match *Match, evs []Event) error {
+	tx, err := e.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err := e.saveEvents(ctx, tx, matchID, evs); err != nil {
+		return err
+	}
+	if winner := winnerOf(evs); winner != NoneWinner {
+		if err := e.recordResult(ctx, tx, matchID, winner); err != nil {
+			return err
+		}
+		if err := releasePlayers(ctx, tx, match); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// releasePlayers sets both players of match back to idle
+func releasePlayers(ctx context.Context, tx pgx.Tx, match *Match) error {
+	_, err := tx.Exec(ctx, "UPDATE user_status SET user_status = $1, game_id = NULL WHERE user_token IN ($2, $3) AND user_status = $4", "idle", match.HostToken.String(), match.GuestToken.String(), "playing")
+	return err
+}
+
+func (e *env) loadEvents(ctx context.Context, matchID uuid.UUID) ([]Event, error) {
+	rows, _ := e.db.Query(ctx, "SELECT data FROM game_events WHERE game_id = $1 ORDER BY seq", matchID.String())
+	var evs []Event
+	var raw []byte
+	_, err := pgx.ForEachRow(rows, []any{&raw}, func() error {
+		var ev Event
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			return fmt.Errorf("event %d of %s: %w", len(evs), matchID, err)
+		}
+		evs = append(evs, ev)
+		return nil
+	})
+	return evs, err
+}
+
+// loadGameState rebuilds a game from its log
+func (e *env) loadGameState(ctx context.Context, matchID uuid.UUID) (*GameState, error) {
+	evs, err := e.loadEvents(ctx, matchID)
+	if err != nil {
+		return nil, err
+	}
+	return replay(evs, -1)
+}
+
+// restoreMatch loads a match from disk into memory, for loadGame and for
+// matches this node hosted before it crashed
+func (e *env) restoreMatch(ctx context.Context, matchID uuid.UUID) (*Match, error) {
+	var hostTokenString, guestTokenString string
+	err := e.db.QueryRow(ctx, `
+		SELECT
+			player_one,
+			player_two
+		FROM
+			games
+		WHERE
+			game_id = $1
+	`, matchID.String()).Scan(&hostTokenString, &guestTokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	hostToken, _ := uuid.Parse(hostTokenString)
+	guestToken, _ := uuid.Parse(guestTokenString)
+
+	gs, err := e.loadGameState(ctx, matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	match := &Match{HostToken: hostToken, GuestToken: guestToken, GameState: gs}
+	actual, loaded := e.matches.LoadOrStore(matchID, match)
+	// finished before we crashed, its cleanup went with us
+	if !loaded && gs.winner != NoneWinner {
+		e.scheduleCleanup(matchID)
+	}
+	return actual.(*Match), nil
+}
+
+// hostedHere tells whether the games table says this node hosts matchID
+func (e *env) hostedHere(ctx context.Context, matchID uuid.UUID) (bool, error) {
+	var hostAddr string
+	err := e.db.QueryRow(ctx, "SELECT host_addr FROM games WHERE game_id = $1", matchID.String()).Scan(&hostAddr)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return hostAddr == e.cfg.AdvertiseAddr, err
+}
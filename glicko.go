@@ -0,0 +1,90 @@
+package main
+
+import "math"
+
+// Glicko-2, http://www.glicko.net/glicko/glicko2.pdf. Every game is its own
+// rating period, so there's one opponent per update.
+
+const (
+	glickoRating     = 1500.0
+	glickoDeviation  = 350.0
+	glickoVolatility = 0.06
+
+	// how much volatility may change, 0.3 to 1.2 in the paper
+	glickoTau = 0.5
+	// between the Glicko and Glicko-2 scales
+	glickoScale   = 173.7178
+	glickoEpsilon = 0.000001
+)
+
+// glicko is one player's rating on the Glicko scale
+type glicko struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+func newGlicko() glicko {
+	return glicko{glickoRating, glickoDeviation, glickoVolatility}
+}
+
+// update rates p after one game against opp, score 1 for a win and 0 for a
+// loss. opp is the rating from before the game.
+func (p glicko) update(opp glicko, score float64) glicko {
+	mu := (p.Rating - glickoRating) / glickoScale
+	phi := p.Deviation / glickoScale
+	muOpp := (opp.Rating - glickoRating) / glickoScale
+	phiOpp := opp.Deviation / glickoScale
+
+	g := 1 / math.Sqrt(1+3*phiOpp*phiOpp/(math.Pi*math.Pi))
+	expected := 1 / (1 + math.Exp(-g*(mu-muOpp)))
+	v := 1 / (g * g * expected * (1 - expected))
+	delta := v * g * (score - expected)
+
+	sigma := newVolatility(phi, p.Volatility, v, delta)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phiNew := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	muNew := mu + phiNew*phiNew*g*(score-expected)
+
+	return glicko{
+		Rating:     glickoScale*muNew + glickoRating,
+		Deviation:  glickoScale * phiNew,
+		Volatility: sigma,
+	}
+}
+
+// newVolatility is step 5 of the paper, the Illinois algorithm
+func newVolatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		return ex*(delta*delta-phi*phi-v-ex)/(2*math.Pow(phi*phi+v+ex, 2)) - (x-a)/(glickoTau*glickoTau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// the worked example in the Glicko-2 paper, step 5
+func TestNewVolatilityPaperExample(t *testing.T) {
+	got := newVolatility(200/glickoScale, 0.06, 1.7785, -0.4834)
+	if math.Abs(got-0.05999) > 0.00001 {
+		t.Fatalf("got volatility %f, the paper has 0.05999", got)
+	}
+}
+
+func TestGlickoUpdate(t *testing.T) {
+	a, b := newGlicko(), newGlicko()
+	won, lost := a.update(b, 1), b.update(a, 0)
+
+	if won.Rating <= glickoRating || lost.Rating >= glickoRating {
+		t.Fatalf("winner at %f, loser at %f", won.Rating, lost.Rating)
+	}
+	if math.Abs((won.Rating-glickoRating)+(lost.Rating-glickoRating)) > 1e-9 {
+		t.Fatalf("equal players moved %f and %f, want the same amount", won.Rating-glickoRating, glickoRating-lost.Rating)
+	}
+	if won.Deviation >= glickoDeviation || lost.Deviation >= glickoDeviation {
+		t.Fatal("a game didn't make the ratings more certain")
+	}
+
+	// beating someone far weaker is worth less than beating an equal
+	weak := glicko{Rating: 1100, Deviation: 50, Volatility: glickoVolatility}
+	if easy := a.update(weak, 1); easy.Rating >= won.Rating {
+		t.Fatalf("beating a weaker player gave %f, an equal one %f", easy.Rating, won.Rating)
+	}
+
+	// and losing to someone far stronger costs less than losing to an equal
+	strong := glicko{Rating: 1900, Deviation: 50, Volatility: glickoVolatility}
+	if expected := b.update(strong, 0); expected.Rating <= lost.Rating {
+		t.Fatalf("losing to a stronger player gave %f, to an equal one %f", expected.Rating, lost.Rating)
+	}
+}
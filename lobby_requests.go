@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// LobbyEntry is a user waiting for someone to join them
+type LobbyEntry struct {
+	Username       string    `json:"username"`
+	Rules          Rules     `json:"rules"`
+	BoardSize      int       `json:"boardSize,omitempty"` // missing means random
+	HostingSince   time.Time `json:"hostingSince"`
+	WaitingSeconds int       `json:"waitingSeconds"`
+}
+
+type lobbyResponse struct {
+	Hosts []LobbyEntry `json:"hosts"`
+}
+
+// invite codes skip 0/O and 1/I so they can be read out loud
+const (
+	inviteAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	inviteLength   = 6
+)
+
+func newInviteCode() (string, error) {
+	b := make([]byte, inviteLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = inviteAlphabet[int(b[i])%len(inviteAlphabet)]
+	}
+	return string(b), nil
+}
+
+// how many invite codes hostMatch draws before giving up. There are 32^6,
+// one clash is already unlikely.
+const inviteCodeTries = 5
+
+// execSavepoint runs one statement in a savepoint, so if it fails the rest
+// of tx can carry on, hostMatch retries clashing invite codes with it
+func execSavepoint(ctx context.Context, tx pgx.Tx, sql string, args ...any) error {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer sp.Rollback(ctx)
+
+	if _, err := sp.Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+	return sp.Commit(ctx)
+}
+
+// isUniqueViolation is a UNIQUE constraint failing, SQLSTATE 23505
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// hostOptions reads what a host wants to play, all fields optional
+func hostOptions(c *gin.Context) (Rules, int, bool, error) {
+	rules, ok := rulesNamed(c.PostForm("rules"))
+	if !ok {
+		return Rules{}, 0, false, ErrUnknownRules.withDetail(c.PostForm("rules"))
+	}
+
+	// 0 is random, same as leaving it out
+	size := 0
+	if sizeString := c.PostForm("board_size"); sizeString != "" {
+		var err error
+		size, err = strconv.Atoi(sizeString)
+		if err != nil || (size != 0 && (size < minBoardSize || size > maxBoardSize)) {
+			return Rules{}, 0, false, ErrBadBoardSize.withDetail(fmt.Sprintf("%d to %d", minBoardSize, maxBoardSize))
+		}
+	}
+
+	private, err := strconv.ParseBool(c.DefaultPostForm("private", "false"))
+	if err != nil {
+		return Rules{}, 0, false, ErrBadPrivate
+	}
+
+	return rules, size, private, nil
+}
+
+// getLobby lists hosting users, longest waiting first
+func (e *env) getLobby(c *gin.Context) {
+	rows, _ := e.db.Query(context.Background(), `
+		SELECT
+			t.username,
+			us.rules,
+			COALESCE(us.board_size, 0),
+			COALESCE(us.hosting_since, NOW())
+		FROM
+			user_status AS us,
+			tokens AS t
+		WHERE us.user_status = $1
+			AND us.invite_code IS NULL
+			AND NOW() - t.lastaccess < make_interval(secs => $2)
+			AND us.user_token = t.token
+		ORDER BY us.hosting_since
+	`, "hosting", e.sessionIdleLimit())
+
+	hosts := []LobbyEntry{}
+	var entry LobbyEntry
+	var rulesName string
+	_, err := pgx.ForEachRow(rows, []any{&entry.Username, &rulesName, &entry.BoardSize, &entry.HostingSince}, func() error {
+		rules, ok := rulesNamed(rulesName)
+		if !ok {
+			// hosted by a node that knows rules we don't, can't join it from here
+			return nil
+		}
+		entry.Rules = rules
+		entry.WaitingSeconds = int(time.Since(entry.HostingSince).Seconds())
+		hosts = append(hosts, entry)
+		return nil
+	})
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, lobbyResponse{Hosts: hosts})
+}
+
+// joinHost joins the user named in the path, if they're hosting in public
+func (e *env) joinHost(c *gin.Context) {
+	e.claimHost(c, "t.username = $2 AND us.invite_code IS NULL", c.Param("username"), ErrHostGone)
+}
+
+// joinInvite joins whoever is hosting with that invite code
+func (e *env) joinInvite(c *gin.Context) {
+	e.claimHost(c, "us.invite_code = $2", strings.ToUpper(c.Param("code")), ErrInviteNotFound)
+}
+
+// claimHost starts a match with the host matching where, $2 being arg, or
+// answers notFound. Claiming the host is a single UPDATE on a row still marked
+// hosting, so when two players race for the same host the second finds it taken.
+func (e *env) claimHost(c *gin.Context, where string, arg string, notFound error) {
+	guestToken := c.MustGet("token").(uuid.UUID)
+
+	tx, err := e.db.Begin(context.Background())
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	defer tx.Rollback(context.Background())
+
+	var hostTokenString, hostAccount, hostAddr, rulesName string
+	var size int
+	err = tx.QueryRow(context.Background(), `
+		UPDATE user_status AS us
+		SET
+			user_status = $1,
+			hosting_since = NULL,
+			invite_code = NULL
+		FROM
+			tokens AS t
+		WHERE us.user_token = t.token
+			AND us.user_status = $3
+			AND us.user_token = (
+				-- one row, an account can host from more than one session
+				SELECT
+					us.user_token
+				FROM
+					user_status AS us,
+					tokens AS t
+				WHERE us.user_token = t.token
+					AND `+where+`
+					AND us.user_status = $3
+					AND NOW() - t.lastaccess < make_interval(secs => $4)
+				ORDER BY us.hosting_since
+				LIMIT 1
+			)
+		RETURNING t.token, COALESCE(t.account_id::text, ''), us.host_addr, us.rules, COALESCE(us.board_size, 0)
+	`, "playing", arg, "hosting", e.sessionIdleLimit()).Scan(&hostTokenString, &hostAccount, &hostAddr, &rulesName, &size)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondError(c, notFound)
+		return
+	}
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	hostToken, _ := uuid.Parse(hostTokenString)
+	// nor another session of the same account, it would be rating itself
+	guestAccount := c.MustGet("claims").(*sessionClaims).Account
+	if hostToken == guestToken || (hostAccount != "" && hostAccount == guestAccount) {
+		respondError(c, ErrJoinSelf)
+		return
+	}
+
+	rules, ok := rulesNamed(rulesName)
+	if !ok {
+		respondError(c, ErrUnknownRules.withDetail(rulesName))
+		return
+	}
+
+	tag, err := tx.Exec(context.Background(), "UPDATE user_status SET user_status = $1 WHERE user_token = $2 AND user_status = $3", "playing", guestToken.String(), "idle")
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		respondError(c, ErrUserNotIdle)
+		return
+	}
+
+	matchID, gs, err := e.startMatch(context.Background(), tx, hostToken, guestToken, hostAddr, rules, size)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	e.sendToHost(c, matchID, &Match{HostToken: hostToken, GuestToken: guestToken, GameState: gs}, hostAddr)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testServer is a node on the database in BATTLEGO_TEST_DB_URL, with
+// sql/init.sql applied. Tests using it are skipped without one.
+func testServer(t *testing.T) (*env, *gin.Engine) {
+	t.Helper()
+	dsn := os.Getenv("BATTLEGO_TEST_DB_URL")
+	if dsn == "" {
+		t.Skip("BATTLEGO_TEST_DB_URL not set")
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	schema, err := os.ReadFile("sql/init.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec(context.Background(), string(schema)); err != nil {
+		t.Fatalf("applying init.sql: %v", err)
+	}
+
+	cfg := defaultConfig()
+	cfg.AdvertiseAddr = "localhost:8080"
+	cfg.SessionKey = strings.Repeat("k", 32)
+	names, err := newUsernamePolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	e := &env{cfg: cfg, db: pool, matches: &sync.Map{}, names: names}
+	if err := e.registerHost(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	registerRoutes(e, router)
+	return e, router
+}
+
+// testName is a username no earlier run has used
+func testName(t *testing.T, prefix string) string {
+	t.Helper()
+	raw := make([]byte, 4)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	return prefix + hex.EncodeToString(raw)
+}
+
+func testRequest(router *gin.Engine, method, path, token string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// testGuest signs up a guest and returns their token
+func testGuest(t *testing.T, router *gin.Engine, name string) string {
+	t.Helper()
+	w := testRequest(router, http.MethodPost, "/v1/user/"+name, "", url.Values{"username": {name}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("signing up %s: %d %s", name, w.Code, w.Body)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Token
+}
+
+func TestJoinHostRace(t *testing.T) {
+	e, router := testServer(t)
+
+	host := testName(t, "race")
+	hostToken := testGuest(t, router, host)
+	if w := testRequest(router, http.MethodPost, "/v1/hostMatch", hostToken, nil); w.Code != http.StatusOK {
+		t.Fatalf("hosting: %d %s", w.Code, w.Body)
+	}
+
+	const guests = 8
+	tokens := make([]string, guests)
+	for i := range tokens {
+		tokens[i] = testGuest(t, router, testName(t, "guest"))
+	}
+
+	codes := make([]int, guests)
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = testRequest(router, http.MethodPost, "/v1/lobby/"+host, token, nil).Code
+		}()
+	}
+	wg.Wait()
+
+	joined := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			joined++
+		case ErrHostGone.Status:
+		default:
+			t.Errorf("join answered %d", code)
+		}
+	}
+	if joined != 1 {
+		t.Fatalf("%d guests joined one host, want 1", joined)
+	}
+
+	var games int
+	err := e.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM games AS g JOIN tokens AS t ON g.player_one = t.token WHERE t.username = $1", host).Scan(&games)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if games != 1 {
+		t.Fatalf("%d games for one host, want 1", games)
+	}
+}
+
+func TestQueueHostRace(t *testing.T) {
+	e, router := testServer(t)
+
+	host := testName(t, "race")
+	hostToken := testGuest(t, router, host)
+	if w := testRequest(router, http.MethodPost, "/v1/hostMatch", hostToken, nil); w.Code != http.StatusOK {
+		t.Fatalf("hosting: %d %s", w.Code, w.Body)
+	}
+
+	const guests = 8
+	names := make([]string, guests)
+	tokens := make([]string, guests)
+	for i := range tokens {
+		names[i] = testName(t, "guest")
+		tokens[i] = testGuest(t, router, names[i])
+	}
+
+	codes := make([]int, guests)
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = testRequest(router, http.MethodPost, "/v1/joinMatch", token, nil).Code
+		}()
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		switch code {
+		case http.StatusOK:
+		case http.StatusAccepted:
+			// nobody left for them, out of the queue so later runs don't get them
+			testRequest(router, http.MethodDelete, "/v1/queue", tokens[i], nil)
+		default:
+			t.Errorf("joinMatch answered %d", code)
+		}
+	}
+
+	// queued guests can pair with each other, but the host and every guest
+	// are in one game at most
+	for _, name := range append(names, host) {
+		var games int
+		err := e.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM games AS g JOIN tokens AS t ON t.token IN (g.player_one, g.player_two) WHERE t.username = $1", name).Scan(&games)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if games > 1 || (name == host && games != 1) {
+			t.Errorf("%s is in %d games", name, games)
+		}
+	}
+}
+
+func TestHostOptionsBoardSize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		form string
+		size int
+		ok   bool
+	}{
+		{"", 0, true},
+		{"board_size=0", 0, true},
+		{"board_size=10", 10, true},
+		{"board_size=3", 0, false},
+		{"board_size=big", 0, false},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/hostMatch", strings.NewReader(tt.form))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		_, size, _, err := hostOptions(c)
+		if (err == nil) != tt.ok || size != tt.size {
+			t.Errorf("%q: got size %d, error %v", tt.form, size, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Requests between nodes are signed with HMAC-SHA256 over the node they're
+// for, the method, path, timestamp, nonce and a hash of the body, with a key from node-keys. The
+// first key signs, all of them verify, so a key is rotated by adding the new
+// one second everywhere, then moving it first, then dropping the old one.
+// Requests older than nodeClockSkew are refused and nonces are remembered
+// that long, so a captured request can't be sent again, and the target is
+// checked so it can't be sent to another node either.
+
+const (
+	nodeKeyHeader       = "X-Node-Key"
+	nodeTimestampHeader = "X-Node-Timestamp"
+	nodeNonceHeader     = "X-Node-Nonce"
+	nodeSignatureHeader = "X-Node-Signature"
+
+	nodeClockSkew   = 30 * time.Second
+	maxInternalBody = 8 << 20
+)
+
+type nodeKey struct {
+	id  string
+	key []byte
+}
+
+// parseNodeKeys reads "id:key,id:key", signing key first
+func parseNodeKeys(s string) ([]nodeKey, error) {
+	var keys []nodeKey
+	seen := map[string]bool{}
+	for i, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		// an entry without its id could be all key, so say where it is, not what
+		id, key, ok := strings.Cut(part, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("node key %d isn't id:key", i+1)
+		}
+		if len(key) < 32 {
+			return nil, fmt.Errorf("node key %s is shorter than 32 bytes", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("node key %s given twice", id)
+		}
+		seen[id] = true
+		keys = append(keys, nodeKey{id: id, key: []byte(key)})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no node keys")
+	}
+	return keys, nil
+}
+
+// nonces remembers the nonces of recent internal requests
+type nonces struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// fresh records nonce and tells whether it was new. Nonces older than
+// nodeClockSkew are forgotten, their requests would be refused anyway.
+func (n *nonces) fresh(nonce string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seen == nil {
+		n.seen = map[string]time.Time{}
+	}
+	for k, at := range n.seen {
+		if now.Sub(at) > 2*nodeClockSkew {
+			delete(n.seen, k)
+		}
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	n.seen[nonce] = now
+	return true
+}
+
+// nodeSignature is the signature for a request to the node advertising
+// target, body being its hex SHA-256
+func nodeSignature(key []byte, target, method, path, timestamp, nonce, body string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(target + "\n" + method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// signNodeRequest adds the signing headers to req for the node advertising
+// target, req's body being body
+func (e *env) signNodeRequest(req *http.Request, target string, body []byte) error {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(raw)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	key := e.nodeKeys[0]
+
+	req.Header.Set(nodeKeyHeader, key.id)
+	req.Header.Set(nodeTimestampHeader, timestamp)
+	req.Header.Set(nodeNonceHeader, nonce)
+	req.Header.Set(nodeSignatureHeader, nodeSignature(key.key, target, req.Method, req.URL.RequestURI(), timestamp, nonce, bodyHash(body)))
+	return nil
+}
+
+// nodeAuth lets through internal requests signed by another node
+func (e *env) nodeAuth(c *gin.Context) {
+	keyID := c.GetHeader(nodeKeyHeader)
+	timestamp := c.GetHeader(nodeTimestampHeader)
+	nonce := c.GetHeader(nodeNonceHeader)
+	sig := c.GetHeader(nodeSignatureHeader)
+	if keyID == "" || timestamp == "" || nonce == "" || sig == "" {
+		abortWithError(c, ErrMissingSignature)
+		return
+	}
+
+	var key []byte
+	for _, k := range e.nodeKeys {
+		if k.id == keyID {
+			key = k.key
+		}
+	}
+	if key == nil {
+		abortWithError(c, ErrBadSignature.withDetail("unknown key"))
+		return
+	}
+
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	now := time.Now()
+	if err != nil || now.Sub(time.Unix(sent, 0)).Abs() > nodeClockSkew {
+		abortWithError(c, ErrBadSignature.withDetail("stale or future timestamp"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxInternalBody))
+	if err != nil {
+		abortWithError(c, ErrBadSignature.withDetail("unreadable body"))
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	// signed for us, so it can't be replayed against another node
+	want := nodeSignature(key, e.cfg.AdvertiseAddr, c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, bodyHash(body))
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		abortWithError(c, ErrBadSignature)
+		return
+	}
+
+	// only once the signature holds, so nobody can burn nonces for us
+	if !e.nonces.fresh(keyID+":"+nonce, now) {
+		abortWithError(c, ErrBadSignature.withDetail("replayed"))
+	}
+}
+
+// nodeRequest builds a signed POST of form to one of addr's /internal routes
+func (e *env) nodeRequest(ctx context.Context, addr, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+"/internal"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, e.signNodeRequest(req, addr, body)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testNode is a node at addr that only has an /internal/echo route
+func testNode(t *testing.T, addr, keys string) (*env, *gin.Engine) {
+	t.Helper()
+	nodeKeys, err := parseNodeKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.AdvertiseAddr = addr
+	e := &env{cfg: cfg, matches: &sync.Map{}, nodeKeys: nodeKeys}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/internal/echo", e.nodeAuth, func(c *gin.Context) {
+		c.String(http.StatusOK, c.PostForm("msg"))
+	})
+	return e, router
+}
+
+var (
+	testKeyA = "a:" + strings.Repeat("a", 32)
+	testKeyB = "b:" + strings.Repeat("b", 32)
+)
+
+func TestNodeAuth(t *testing.T) {
+	sender, _ := testNode(t, "node1:8080", testKeyA)
+	_, receiver := testNode(t, "node2:8080", testKeyA)
+
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		receiver.ServeHTTP(w, req)
+		return w
+	}
+	signed := func(target, body string) *http.Request {
+		req, err := sender.nodeRequest(context.Background(), target, "/echo", []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	req := signed("node2:8080", "msg=hi")
+	if w := send(req); w.Code != http.StatusOK || w.Body.String() != "hi" {
+		t.Fatalf("signed request got %d %s", w.Code, w.Body)
+	}
+
+	t.Run("replayed", func(t *testing.T) {
+		again := signedCopy(t, req, "msg=hi")
+		if w := send(again); w.Code != ErrBadSignature.Status {
+			t.Fatalf("replay got %d, want %d", w.Code, ErrBadSignature.Status)
+		}
+	})
+
+	t.Run("for another node", func(t *testing.T) {
+		if w := send(signed("node3:8080", "msg=hi")); w.Code != ErrBadSignature.Status {
+			t.Fatalf("got %d, want %d", w.Code, ErrBadSignature.Status)
+		}
+	})
+
+	t.Run("body changed", func(t *testing.T) {
+		tampered := signedCopy(t, signed("node2:8080", "msg=hi"), "msg=bye")
+		if w := send(tampered); w.Code != ErrBadSignature.Status {
+			t.Fatalf("got %d, want %d", w.Code, ErrBadSignature.Status)
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		plain := httptest.NewRequest(http.MethodPost, "/internal/echo", strings.NewReader("msg=hi"))
+		if w := send(plain); w.Code != ErrMissingSignature.Status {
+			t.Fatalf("got %d, want %d", w.Code, ErrMissingSignature.Status)
+		}
+	})
+}
+
+// signedCopy is req with its signing headers but body instead
+func signedCopy(t *testing.T, req *http.Request, body string) *http.Request {
+	t.Helper()
+	c := httptest.NewRequest(req.Method, req.URL.RequestURI(), strings.NewReader(body))
+	c.Header = req.Header.Clone()
+	return c
+}
+
+func TestNodeAuthKeyRotation(t *testing.T) {
+	// mid rotation: the sender signs with b, the receiver still signs with a
+	// but verifies both
+	sender, _ := testNode(t, "node1:8080", testKeyB+","+testKeyA)
+	_, receiver := testNode(t, "node2:8080", testKeyA+","+testKeyB)
+
+	req, err := sender.nodeRequest(context.Background(), "node2:8080", "/echo", []byte("msg=hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+
+	_, old := testNode(t, "node2:8080", testKeyA)
+	req, err = sender.nodeRequest(context.Background(), "node2:8080", "/echo", []byte("msg=hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	old.ServeHTTP(w, req)
+	if w.Code != ErrBadSignature.Status {
+		t.Fatalf("unknown key got %d, want %d", w.Code, ErrBadSignature.Status)
+	}
+}
+
+func TestParseNodeKeysHidesKeys(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+	for _, keys := range []string{secret, testKeyA + "," + secret, ":" + secret} {
+		_, err := parseNodeKeys(keys)
+		if err == nil {
+			t.Fatalf("parseNodeKeys took an entry without an id")
+		}
+		if strings.Contains(err.Error(), secret) {
+			t.Errorf("error gives the key away: %v", err)
+		}
+	}
+}
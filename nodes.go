@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var errNoNodes = errors.New("no other active nodes")
+
+// host statuses in the hosts table
+const (
+	hostActive   = "active"
+	hostDraining = "draining"
+)
+
+// a node whose last_seen is older than this has crashed or lost the
+// database, its matches are up for adoption. heartbeat runs every reapInterval.
+const hostDeadAfter = 3 * reapInterval
+
+// registerHost marks this node as taking games, also after a restart
+func (e *env) registerHost(ctx context.Context) error {
+	_, err := e.db.Exec(ctx, `
+		INSERT INTO hosts (host_addr, status, last_seen) VALUES ($1, $2, NOW())
+		ON CONFLICT (host_addr) DO UPDATE SET status = EXCLUDED.status, last_seen = EXCLUDED.last_seen
+	`, e.cfg.AdvertiseAddr, hostActive)
+	return err
+}
+
+// heartbeat tells the other nodes this one is still up, see hostDeadAfter
+func (e *env) heartbeat(ctx context.Context) error {
+	_, err := e.db.Exec(ctx, "UPDATE hosts SET last_seen = NOW() WHERE host_addr = $1", e.cfg.AdvertiseAddr)
+	return err
+}
+
+// pickNode returns a random live node other than this one, "" if there are none
+func (e *env) pickNode(ctx context.Context) (string, error) {
+	var addr string
+	err := e.db.QueryRow(ctx, `
+		SELECT host_addr
+		FROM hosts
+		WHERE status = $1 AND host_addr != $2 AND NOW() - last_seen <= make_interval(secs => $3)
+		ORDER BY RANDOM()
+		LIMIT 1
+	`, hostActive, e.cfg.AdvertiseAddr, hostDeadAfter.Seconds()).Scan(&addr)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return addr, err
+}
+
+// callNode POSTs form to one of addr's /internal routes, signed, see node_auth.go
+func (e *env) callNode(ctx context.Context, addr, path string, form url.Values) error {
+	req, err := e.nodeRequest(ctx, addr, path, []byte(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s%s: %s: %s", addr, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
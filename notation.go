@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Battleship game notation, a whole game as text so people can share them
+// the way chess players share PGN:
+//
+//	[Game "6f1c0a9e-..."]
+//	[Date "2026-10-19T12:00:00Z"]
+//	[Size "10x10"]
+//	[Seed "8674665223082153551"]
+//	[Rules "classic"]
+//	[First "host"]
+//	[HostFleet "A1-D1 E4-E7 H8-K8"]
+//	[GuestFleet "B2-B5 D7-G7 J1-J4"]
+//	[Result "host"]
+//
+//	1. B7 C3# 2. D4 E5x ...
+//
+// Squares are column letter then row from 1, like battlecli takes them.
+// Ships go from one end to the other. Each numbered turn is the first
+// player's shot then the other's; a bare square is a miss, "x" a hit, "#" a
+// hit that sinks. Result is host, guest or * while the game goes on, a
+// forfeit adds [Forfeit "<who quit>"]. Seed and Rules are optional, Rules
+// defaults to classic. With a seed the board size, fleets and first player
+// have to be what that seed deals under those rules.
+
+var ErrBadNotation = errors.New("bad notation")
+
+const notationOngoing = "*"
+
+var notationTag = regexp.MustCompile(`^\[(\w+)\s+"([^"]*)"\]$`)
+
+// notatedGame is a parsed game, checked for syntax but not against the rules
+type notatedGame struct {
+	Tags    map[string]string
+	W, H    int
+	Seed    int64
+	HasSeed bool
+	First   PlayerType
+	Fleets  [2][]*Ship
+	Shots   []notatedShot
+	Result  PlayerType
+	Forfeit PlayerType
+}
+
+type notatedShot struct {
+	X, Y      int
+	Hit, Sunk bool
+}
+
+// formatNotation writes out a game from its log
+func formatNotation(gameID string, events []Event) (string, error) {
+	gs, err := replay(events, -1)
+	if err != nil {
+		return "", err
+	}
+
+	tags := [][2]string{{"Game", gameID}}
+	result, forfeit := notationOngoing, ""
+	var shots []string
+
+	for _, ev := range events {
+		switch ev.Kind {
+		case EventCreated:
+			tags = append(tags,
+				[2]string{"Date", ev.At.UTC().Format(time.RFC3339)},
+				[2]string{"Size", fmt.Sprintf("%dx%d", ev.Width, ev.Height)})
+			if ev.Seed != 0 {
+				tags = append(tags, [2]string{"Seed", strconv.FormatInt(ev.Seed, 10)})
+			}
+			if ev.Rules != "" {
+				tags = append(tags, [2]string{"Rules", ev.Rules})
+			}
+			tags = append(tags, [2]string{"First", playerName(ev.First)})
+		case EventShipsPlaced:
+			fleet := make([]string, len(ev.Ships))
+			for i, s := range ev.Ships {
+				fleet[i] = square(s.Startx, s.Starty) + "-" + square(s.Endx, s.Endy)
+			}
+			key := "HostFleet"
+			if ev.Player == Guest {
+				key = "GuestFleet"
+			}
+			tags = append(tags, [2]string{key, strings.Join(fleet, " ")})
+		case EventShotFired:
+			shot := square(ev.X, ev.Y)
+			if ev.Hit {
+				shot += "x"
+			}
+			shots = append(shots, shot)
+		case EventShipSunk:
+			shots[len(shots)-1] = strings.TrimSuffix(shots[len(shots)-1], "x") + "#"
+		case EventForfeit:
+			forfeit = playerName(ev.Player)
+		}
+	}
+	if gs.winner != NoneWinner {
+		result = playerName(gs.winner)
+	}
+	tags = append(tags, [2]string{"Result", result})
+	if forfeit != "" {
+		tags = append(tags, [2]string{"Forfeit", forfeit})
+	}
+
+	var sb strings.Builder
+	for _, t := range tags {
+		fmt.Fprintf(&sb, "[%s %q]\n", t[0], t[1])
+	}
+	sb.WriteString("\n")
+
+	line := 0
+	for i, shot := range shots {
+		tok := shot
+		if i%2 == 0 {
+			tok = strconv.Itoa(i/2+1) + ". " + shot
+		}
+		if line > 0 && line+len(tok) >= 80 {
+			sb.WriteString("\n")
+			line = 0
+		} else if line > 0 {
+			sb.WriteString(" ")
+			line++
+		}
+		sb.WriteString(tok)
+		line += len(tok)
+	}
+	sb.WriteString(" " + result + "\n")
+
+	return sb.String(), nil
+}
+
+// parseNotation reads one game, syntax only, see toEvents for the rules
+func parseNotation(text string) (*notatedGame, error) {
+	ng := &notatedGame{Tags: map[string]string{}, Result: NoneWinner, Forfeit: NoneWinner}
+
+	sc := bufio.NewScanner(strings.NewReader(text))
+	var moveText strings.Builder
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "[") {
+			moveText.WriteString(line + " ")
+			continue
+		}
+		m := notationTag.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("%w: line %d: tags look like [Name \"value\"]", ErrBadNotation, n)
+		}
+		ng.Tags[m[1]] = m[2]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, required := range []string{"Size", "First", "HostFleet", "GuestFleet", "Result"} {
+		if _, ok := ng.Tags[required]; !ok {
+			return nil, fmt.Errorf("%w: missing tag %s", ErrBadNotation, required)
+		}
+	}
+
+	_, err := fmt.Sscanf(ng.Tags["Size"], "%dx%d", &ng.W, &ng.H)
+	if err != nil || ng.W < minBoardSize || ng.H < minBoardSize || ng.W > maxBoardSize || ng.H > maxBoardSize {
+		return nil, fmt.Errorf("%w: Size is WxH, each %d to %d", ErrBadNotation, minBoardSize, maxBoardSize)
+	}
+
+	if seed, ok := ng.Tags["Seed"]; ok {
+		parsed, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: Seed isn't a number", ErrBadNotation)
+		}
+		ng.Seed, ng.HasSeed = parsed, true
+	}
+
+	if ng.First, err = parsePlayerName(ng.Tags["First"], false); err != nil {
+		return nil, err
+	}
+	if ng.Result, err = parsePlayerName(ng.Tags["Result"], true); err != nil {
+		return nil, err
+	}
+	if quitter, ok := ng.Tags["Forfeit"]; ok {
+		if ng.Forfeit, err = parsePlayerName(quitter, false); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, key := range []string{"HostFleet", "GuestFleet"} {
+		for _, tok := range strings.Fields(ng.Tags[key]) {
+			from, to, ok := strings.Cut(tok, "-")
+			if !ok {
+				return nil, fmt.Errorf("%w: %s: ship %q isn't A1-A4", ErrBadNotation, key, tok)
+			}
+			sx, sy, err1 := parseSquare(from)
+			ex, ey, err2 := parseSquare(to)
+			if err1 != nil || err2 != nil || (sx != ex && sy != ey) || sx > ex || sy > ey {
+				return nil, fmt.Errorf("%w: %s: ship %q isn't a straight line", ErrBadNotation, key, tok)
+			}
+			dir := Vertical
+			if sy == ey && sx != ex {
+				dir = Horizontal
+			}
+			ng.Fleets[i] = append(ng.Fleets[i], newShip(sx, sy, ex, ey, dir))
+		}
+	}
+
+	for _, tok := range strings.Fields(moveText.String()) {
+		if strings.HasSuffix(tok, ".") || tok == notationOngoing || tok == ng.Tags["Result"] {
+			continue
+		}
+		shot := notatedShot{}
+		switch {
+		case strings.HasSuffix(tok, "#"):
+			shot.Hit, shot.Sunk = true, true
+		case strings.HasSuffix(tok, "x"):
+			shot.Hit = true
+		}
+		shot.X, shot.Y, err = parseSquare(strings.TrimRight(tok, "x#"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: shot %d: %v", ErrBadNotation, len(ng.Shots)+1, err)
+		}
+		ng.Shots = append(ng.Shots, shot)
+	}
+
+	return ng, nil
+}
+
+// toEvents plays the notated game by the rules and returns its log. Any shot
+// whose result doesn't match what the boards say, or a result that doesn't
+// follow from the shots, fails.
+func (ng *notatedGame) toEvents() ([]Event, error) {
+	rules, ok := rulesNamed(ng.Tags["Rules"])
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown Rules %q", ErrBadNotation, ng.Tags["Rules"])
+	}
+
+	if ng.HasSeed {
+		dealt, err := newGameStateFromSeed(ng.Seed, rules, ng.W)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadNotation, err)
+		}
+		if err := ng.matchesDeal(dealt); err != nil {
+			return nil, err
+		}
+	} else if err := ng.matchesRules(rules); err != nil {
+		return nil, err
+	}
+
+	gs := &GameState{winner: NoneWinner}
+	err := gs.applyAll(gs.stamp([]Event{
+		{Kind: EventCreated, Player: NoneWinner, Width: ng.W, Height: ng.H, First: ng.First, Seed: ng.Seed, Rules: ng.Tags["Rules"]},
+		{Kind: EventShipsPlaced, Player: Host, Ships: ng.Fleets[Host]},
+		{Kind: EventShipsPlaced, Player: Guest, Ships: ng.Fleets[Guest]},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadNotation, err)
+	}
+
+	shooter := ng.First
+	for i, shot := range ng.Shots {
+		evs, err := gs.fire(shot.X, shot.Y, shooter)
+		if err != nil {
+			return nil, fmt.Errorf("%w: shot %d at %s: %v", ErrBadNotation, i+1, square(shot.X, shot.Y), err)
+		}
+		if evs[0].Hit != shot.Hit {
+			return nil, fmt.Errorf("%w: shot %d at %s: notation says hit=%t, the board says %t", ErrBadNotation, i+1, square(shot.X, shot.Y), shot.Hit, evs[0].Hit)
+		}
+		if sunk := len(evs) > 1; sunk != shot.Sunk {
+			return nil, fmt.Errorf("%w: shot %d at %s: notation says sunk=%t, the board says %t", ErrBadNotation, i+1, square(shot.X, shot.Y), shot.Sunk, sunk)
+		}
+		if err := gs.applyAll(evs); err != nil {
+			return nil, fmt.Errorf("%w: shot %d: %v", ErrBadNotation, i+1, err)
+		}
+		shooter = opponent(shooter)
+	}
+
+	if ng.Forfeit != NoneWinner {
+		evs, err := gs.forfeit(ng.Forfeit)
+		if err != nil {
+			return nil, fmt.Errorf("%w: forfeit after the game ended", ErrBadNotation)
+		}
+		if err := gs.applyAll(evs); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadNotation, err)
+		}
+	}
+
+	if gs.winner != ng.Result {
+		return nil, fmt.Errorf("%w: Result says %s, the game says %s", ErrBadNotation, playerName(ng.Result), playerName(gs.winner))
+	}
+
+	return gs.events, nil
+}
+
+// matchesDeal checks the setup against what the seed deals
+func (ng *notatedGame) matchesDeal(dealt *GameState) error {
+	if dealt.boardHost.W != ng.W || dealt.boardHost.H != ng.H || dealt.evens != ng.First {
+		return fmt.Errorf("%w: Size or First isn't what seed %d deals", ErrBadNotation, ng.Seed)
+	}
+	for i, board := range []*Board{dealt.boardHost, dealt.boardGuest} {
+		fleet := ng.Fleets[i]
+		if len(fleet) != len(board.Ships) {
+			return fmt.Errorf("%w: fleets aren't what seed %d deals", ErrBadNotation, ng.Seed)
+		}
+		for j, s := range board.Ships {
+			if s.Startx != fleet[j].Startx || s.Starty != fleet[j].Starty || s.Endx != fleet[j].Endx || s.Endy != fleet[j].Endy {
+				return fmt.Errorf("%w: fleets aren't what seed %d deals", ErrBadNotation, ng.Seed)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesRules checks each fleet has one ship of every length the rules
+// deal, in any order. The seed covers this when there is one.
+func (ng *notatedGame) matchesRules(rules Rules) error {
+	want := append([]int(nil), rules.ShipLengths...)
+	sort.Ints(want)
+	for i, key := range []string{"HostFleet", "GuestFleet"} {
+		var got []int
+		for _, s := range ng.Fleets[i] {
+			got = append(got, max(s.Endx-s.Startx, s.Endy-s.Starty)+1)
+		}
+		sort.Ints(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			return fmt.Errorf("%w: %s has ships of %v squares, %s rules deal %v", ErrBadNotation, key, got, rules.Name, rules.ShipLengths)
+		}
+	}
+	return nil
+}
+
+// square is "B7" for 1, 6
+func square(x, y int) string {
+	return string(rune('A'+x)) + strconv.Itoa(y+1)
+}
+
+func parseSquare(s string) (int, int, error) {
+	s = strings.ToUpper(s)
+	if len(s) < 2 || s[0] < 'A' || s[0] > 'Z' {
+		return 0, 0, fmt.Errorf("%q isn't a square", s)
+	}
+	row, err := strconv.Atoi(s[1:])
+	if err != nil || row < 1 {
+		return 0, 0, fmt.Errorf("%q isn't a square", s)
+	}
+	return int(s[0] - 'A'), row - 1, nil
+}
+
+func playerName(p PlayerType) string {
+	switch p {
+	case Host:
+		return "host"
+	case Guest:
+		return "guest"
+	}
+	return notationOngoing
+}
+
+func parsePlayerName(s string, ongoingOK bool) (PlayerType, error) {
+	switch strings.ToLower(s) {
+	case "host":
+		return Host, nil
+	case "guest":
+		return Guest, nil
+	case notationOngoing:
+		if ongoingOK {
+			return NoneWinner, nil
+		}
+	}
+	return NoneWinner, fmt.Errorf("%w: %q isn't host or guest", ErrBadNotation, s)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// playedGame deals seed and has both players sweep the board row by row
+// until someone wins
+func playedGame(t *testing.T, seed int64) *GameState {
+	t.Helper()
+	gs, err := newGameStateFromSeed(seed, ruleSets[defaultRules], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := map[PlayerType]int{}
+	shooter := gs.evens
+	for gs.winner == NoneWinner {
+		i := next[shooter]
+		next[shooter]++
+		w := gs.boardHost.W
+		evs, err := gs.fire(i%w, i/w, shooter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gs.applyAll(evs); err != nil {
+			t.Fatal(err)
+		}
+		shooter = opponent(shooter)
+	}
+	return gs
+}
+
+func TestNotationRoundTrip(t *testing.T) {
+	gs := playedGame(t, 42)
+
+	text, err := formatNotation("game", gs.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ng, err := parseNotation(text)
+	if err != nil {
+		t.Fatalf("parsing what we wrote: %v\n%s", err, text)
+	}
+	evs, err := ng.toEvents()
+	if err != nil {
+		t.Fatalf("replaying what we wrote: %v\n%s", err, text)
+	}
+
+	replayed := &GameState{winner: NoneWinner}
+	if err := replayed.applyAll(evs); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.winner != gs.winner || len(replayed.moves) != len(gs.moves) {
+		t.Fatalf("replay has winner %d after %d moves, want %d after %d", replayed.winner, len(replayed.moves), gs.winner, len(gs.moves))
+	}
+}
+
+func TestNotationRejects(t *testing.T) {
+	gs := playedGame(t, 7)
+	text, err := formatNotation("game", gs.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := strings.Split(strings.Split(text, `[Size "`)[1], `"`)[0]
+
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"board too small with seed", strings.Replace(text, `[Size "`+size+`"]`, `[Size "3x3"]`, 1)},
+		{"board too small without seed", strings.Replace(strings.Replace(text, `[Size "`+size+`"]`, `[Size "3x3"]`, 1), `[Seed "7"]`, "", 1)},
+		{"board too big", strings.Replace(text, `[Size "`+size+`"]`, `[Size "26x26"]`, 1)},
+		{"other seed", strings.Replace(text, `[Seed "7"]`, `[Seed "8"]`, 1)},
+		{"fleet against the rules without seed", strings.Replace(strings.Replace(text, `[Rules "classic"]`, `[Rules "fleet"]`, 1), `[Seed "7"]`, "", 1)},
+		{"wrong result", strings.Replace(text, `[Result "`+playerName(gs.winner)+`"]`, `[Result "`+playerName(opponent(gs.winner))+`"]`, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.text == text {
+				t.Fatal("test didn't change the notation")
+			}
+			ng, err := parseNotation(tt.text)
+			if err == nil {
+				_, err = ng.toEvents()
+			}
+			if !errors.Is(err, ErrBadNotation) {
+				t.Fatalf("got %v, want ErrBadNotation", err)
+			}
+		})
+	}
+}
+
+func TestNewBoardFromRandomGivesUp(t *testing.T) {
+	_, err := newGameStateFromSeed(1, ruleSets["fleet"], 3)
+	if !errors.Is(err, ErrFleetDoesntFit) {
+		t.Fatalf("got %v, want ErrFleetDoesntFit", err)
+	}
+}
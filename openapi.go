@@ -0,0 +1,333 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const openAPIVersion = "3.0.3"
+
+// routeDoc is the bit of a route gin doesn't know about. Routes without one
+// still end up in the document, just with less to say for themselves.
+type routeDoc struct {
+	Summary  string
+	Auth     bool     // needs a user token (userAuth)
+	Internal bool     // needs a signed request from another node (nodeAuth)
+	Role     string   // account role needed (requireRole)
+	Form     []string // required form fields
+	OptForm  []string // optional form fields
+	Query    []string // optional query parameters
+	Response any      // zero value of the 2xx body, nil means a plain message
+	Status   int      // 2xx status, defaults to 200
+	Text     string   // content type of a plain text 2xx body, Response is ignored
+}
+
+// keyed by "METHOD /gin/path" without the version prefix, see unversionedPath.
+// Legacy aliases share their successor's entry.
+var routeDocs = map[string]routeDoc{
+	"POST /user/:username":               {Summary: "Create a throwaway user and get a token", Form: []string{"username"}, Status: http.StatusCreated, Response: tokenResponse{}},
+	"POST /accounts":                     {Summary: "Register an account that keeps its games and rating. Log in to play", Form: []string{"username", "password"}, Status: http.StatusCreated},
+	"POST /login":                        {Summary: "Log in to an account and get a session token", Form: []string{"username", "password"}, Status: http.StatusCreated, Response: tokenResponse{}},
+	"POST /extendSession":                {Summary: "Swap your token for one that expires later", Auth: true, Response: tokenResponse{}},
+	"POST /logout":                       {Summary: "End your session, forfeiting any match you're in. 302 means do it on the node in location, it hosts your match", Auth: true},
+	"GET /sessions":                      {Summary: "Your account's live sessions", Auth: true, Response: sessionsResponse{}},
+	"DELETE /sessions/:session_id":       {Summary: "End one of your account's sessions, like /logout for it", Auth: true},
+	"PUT /admin/accounts/:username/role": {Summary: "Make an account a player, moderator or admin", Auth: true, Role: roleAdmin, Form: []string{"role"}},
+	"GET /admin/matches":                 {Summary: "Live matches on this node, oldest first", Auth: true, Role: roleModerator, Response: adminMatchesResponse{}},
+	"GET /admin/matches/:match_id":       {Summary: "A live match with both fleets. 302 means ask the node in location", Auth: true, Role: roleModerator, Response: AdminMatchView{}},
+	"POST /admin/matches/:match_id/end":  {Summary: "End a live match. outcome host or guest wins it for them, abandon drops it unrated. 302 means ask the node in location", Auth: true, Role: roleAdmin, Form: []string{"outcome"}},
+	"POST /admin/users/:username/kick":   {Summary: "End all of a user's sessions, forfeiting their matches. 302 means finish on the node in location", Auth: true, Role: roleModerator},
+	"POST /joinMatch":                    {Summary: "Same as POST /queue, kept for old clients", Auth: true, Response: joinResponse{}},
+	"POST /queue":                        {Summary: "Queue for the closest rated opponent, queued players or public hosts. 200/302 with the match if there's one now, 202 to wait and poll /game/match", Auth: true, Response: joinResponse{}},
+	"GET /queue":                         {Summary: "Your queue status and the rating gap you currently accept", Auth: true, Response: queueResponse{}},
+	"DELETE /queue":                      {Summary: "Leave the queue", Auth: true},
+	"POST /hostMatch":                    {Summary: "Start looking for other players. rules is a rule set name, board_size 0 or absent is random, private=true gets an invite code instead of a lobby spot", Auth: true, OptForm: []string{"rules", "board_size", "private"}, Response: hostResponse{}},
+	"DELETE /hostMatch":                  {Summary: "Stop looking for other players", Auth: true},
+	"GET /lobby":                         {Summary: "Public hosts waiting for someone to join, longest waiting first", Response: lobbyResponse{}},
+	"POST /lobby/:username":              {Summary: "Join a public host from the lobby. 302 means play on the node in location", Auth: true, Response: joinResponse{}},
+	"POST /invite/:code":                 {Summary: "Join a private host with their invite code. 302 means play on the node in location", Auth: true, Response: joinResponse{}},
+	"POST /internal/loadGame":            {Summary: "Load a match into this node's memory", Internal: true, Form: []string{"game_id"}},
+	"POST /internal/importMatch":         {Summary: "Take over a live match from a draining node", Internal: true, Form: []string{"game_id", "match"}},
+	"GET /game/match":                    {Summary: "Find your current match. 302 means talk to the node in location", Auth: true, Response: matchResponse{}},
+	"GET /game/play":                     {Summary: "Current game state, with only your board", Auth: true, Form: []string{"match_id"}, Response: CensoredGameState{}},
+	"POST /game/play":                    {Summary: "Fire at the enemy board", Auth: true, Form: []string{"match_id", "x", "y"}, Response: moveResponse{}},
+	"GET /game/replay/:game_id":          {Summary: "Rebuild a game from its event log at ?move=N, default latest. Finished games are public and in full", Auth: true, Query: []string{"move"}, Response: ReplayView{}},
+	"GET /game/replay/:game_id/export":   {Summary: "A finished game in battleship notation, see notation.go", Auth: true, Text: "text/plain"},
+	"POST /replay/import":                {Summary: "Check a game in battleship notation against the rules and show it in full", Form: []string{"notation"}, Response: ReplayView{}},
+	"GET /spectate/:match_id":            {Summary: "Watch a live match in fog of war, behind by spectate-delay, and both fleets once it's over. 302 means ask the node in location", Response: SpectatorView{}},
+	"GET /rating/:username":              {Summary: "A player's Glicko-2 rating, deviation and latest changes", Response: RatingView{}},
+	"GET /openapi.json":                  {Summary: "This document"},
+}
+
+// response bodies that are gin.H in the handlers, here so they get schemas
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+type hostResponse struct {
+	Message    string `json:"message"`
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+type tokenResponse struct {
+	Message string `json:"message"`
+	Token   string `json:"token"`
+}
+
+type joinResponse struct {
+	Message  string `json:"message"`
+	MatchID  string `json:"matchID,omitempty"`
+	Location string `json:"location,omitempty"`
+}
+
+type matchResponse struct {
+	Message  string `json:"message"`
+	GameID   string `json:"game_id"`
+	Location string `json:"location,omitempty"`
+}
+
+type moveResponse struct {
+	Message string `json:"message"`
+	Hit     bool   `json:"hit"`
+	Win     bool   `json:"win"`
+}
+
+// types with enum values, reflection can't tell us these
+var enumDocs = map[reflect.Type]string{
+	reflect.TypeOf(PlayerType(0)): "0 = host, 1 = guest, 2 = nobody",
+	reflect.TypeOf(Direction(0)):  "0 = horizontal, 1 = vertical",
+}
+
+var ginParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// openAPISpec builds the document from whatever is registered on the router
+func openAPISpec(routes gin.RoutesInfo) map[string]any {
+	components := map[string]any{}
+	errRef := schemaFor(reflect.TypeOf(APIError{}), components)
+
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		doc, known := routeDocs[route.Method+" "+unversionedPath(route.Method, route.Path)]
+		if !known {
+			doc.Summary = route.Handler
+		}
+		version := routeVersion(route.Method, route.Path)
+
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+
+		op := map[string]any{
+			"summary":     doc.Summary,
+			"operationId": operationID(version, route.Handler),
+		}
+		if doc.Role != "" {
+			op["description"] = "Needs an account with the " + doc.Role + " role or higher."
+		}
+		if version == "legacy" {
+			op["deprecated"] = true
+			op["description"] = "Use " + legacyRoutes[route.Method+" "+route.Path] + " instead."
+		}
+
+		var params []any
+		for _, m := range ginParam.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range doc.Query {
+			params = append(params, map[string]any{
+				"name": q, "in": "query", "required": false,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		form := doc.Form
+		if doc.Auth {
+			op["security"] = []any{map[string]any{"bearerAuth": []string{}}, map[string]any{"cookieAuth": []string{}}}
+		}
+		if doc.Internal {
+			op["security"] = []any{map[string]any{"nodeSignature": []string{}}}
+		}
+
+		// GETs have no body, gin reads their form from the query string
+		if route.Method == http.MethodGet {
+			for _, f := range form {
+				params = append(params, map[string]any{
+					"name": f, "in": "query", "required": true,
+					"schema": map[string]any{"type": "string"},
+				})
+			}
+			for _, f := range doc.OptForm {
+				params = append(params, map[string]any{
+					"name": f, "in": "query", "required": false,
+					"schema": map[string]any{"type": "string"},
+				})
+			}
+			form = nil
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		if route.Method != http.MethodGet && len(form)+len(doc.OptForm) > 0 {
+			props := map[string]any{}
+			for _, f := range append(form, doc.OptForm...) {
+				props[f] = map[string]any{"type": "string"}
+			}
+			schema := map[string]any{"type": "object", "properties": props}
+			// OpenAPI wants required left out rather than empty
+			if len(form) > 0 {
+				schema["required"] = form
+			}
+			op["requestBody"] = map[string]any{
+				"required": len(form) > 0,
+				"content": map[string]any{
+					"application/x-www-form-urlencoded": map[string]any{"schema": schema},
+				},
+			}
+		}
+
+		body := doc.Response
+		if body == nil {
+			body = messageResponse{}
+		}
+		status := doc.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		content := jsonContent(schemaFor(reflect.TypeOf(body), components))
+		if doc.Text != "" {
+			content = map[string]any{doc.Text: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		op["responses"] = map[string]any{
+			strconv.Itoa(status): map[string]any{
+				"description": http.StatusText(status),
+				"content":     content,
+			},
+			"default": map[string]any{
+				"description": "Error, see code",
+				"content":     jsonContent(errRef),
+			},
+		}
+
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "battlegov3",
+			"version": "0.1.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": components,
+			"securitySchemes": map[string]any{
+				"bearerAuth":    map[string]any{"type": "http", "scheme": "bearer", "description": "The token from /user/{username}, /login or /extendSession"},
+				"cookieAuth":    map[string]any{"type": "apiKey", "in": "cookie", "name": sessionCookie, "description": "Only read on GET and HEAD"},
+				"nodeSignature": map[string]any{"type": "apiKey", "in": "header", "name": nodeSignatureHeader, "description": "HMAC-SHA256 with a node key, along with " + nodeKeyHeader + ", " + nodeTimestampHeader + " and " + nodeNonceHeader + ", see node_auth.go"},
+			},
+		},
+	}
+}
+
+// schemaFor returns an inline schema, or a $ref for named structs which it
+// adds to components as a side effect
+func schemaFor(t reflect.Type, components map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case reflect.TypeOf(uuid.UUID{}):
+		return map[string]any{"type": "string", "format": "uuid"}
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	if desc, ok := enumDocs[t]; ok {
+		return map[string]any{"type": "integer", "description": desc}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), components)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), components)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, done := components[t.Name()]; done {
+			return ref
+		}
+		// placeholder first, in case of self reference
+		components[t.Name()] = map[string]any{}
+
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaFor(f.Type, components)
+		}
+		components[t.Name()] = map[string]any{"type": "object", "properties": props}
+		return ref
+	}
+
+	return map[string]any{}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// operationID turns "v1", "main.(*env).postUsers-fm" into "v1_postUsers".
+// The version keeps ids unique across aliases of the same handler.
+func operationID(version, handler string) string {
+	if i := strings.LastIndex(handler, "."); i >= 0 {
+		handler = handler[i+1:]
+	}
+	handler = strings.TrimSuffix(handler, "-fm")
+	if version == "" {
+		return handler
+	}
+	return version + "_" + handler
+}
+
+// serveOpenAPI builds the document on first request, by which point every
+// route has been registered
+func serveOpenAPI(router *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	var spec map[string]any
+	return func(c *gin.Context) {
+		once.Do(func() { spec = openAPISpec(router.Routes()) })
+		c.IndentedJSON(http.StatusOK, spec)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// The queue pairs players by rating. Queued players are matched with each
+// other and with public hosts from the lobby, whoever is closest in rating
+// as long as the gap is one either side will accept. That gap grows the
+// longer they wait, so everybody gets a game eventually.
+
+const (
+	queueInterval     = 2 * time.Second
+	queueBaseGap      = 100.0
+	queueGapPerSecond = 5.0
+)
+
+// queueEntry is a queued player, or a public host waiting in the lobby
+type queueEntry struct {
+	token    uuid.UUID
+	account  uuid.UUID // uuid.Nil for guests
+	rating   float64
+	since    time.Time
+	hosting  bool
+	hostAddr string
+	rules    string
+	size     int
+}
+
+// queuePair is a match the queue made
+type queuePair struct {
+	matchID  uuid.UUID
+	host     uuid.UUID
+	guest    uuid.UUID
+	hostAddr string
+}
+
+type queueResponse struct {
+	Status         string  `json:"status"`
+	WaitingSeconds int     `json:"waitingSeconds,omitempty"`
+	Gap            float64 `json:"gap,omitempty"`
+}
+
+// queueGap is how far apart in rating a player will accept after waiting
+func queueGap(waited time.Duration) float64 {
+	return queueBaseGap + queueGapPerSecond*waited.Seconds()
+}
+
+// pickPairs pairs entries, oldest first, each with the closest rating both
+// of them accept. Two hosts never meet, they each picked rules, and nobody
+// plays another session of their own account. The host of a pair is the
+// lobby host if there is one, else whoever waited longer.
+func pickPairs(entries []queueEntry, now time.Time) [][2]queueEntry {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].since.Before(entries[j].since) })
+
+	used := make([]bool, len(entries))
+	var pairs [][2]queueEntry
+	for i, a := range entries {
+		if used[i] {
+			continue
+		}
+
+		best, bestDiff := -1, math.Inf(1)
+		for j := i + 1; j < len(entries); j++ {
+			b := entries[j]
+			if used[j] || (a.hosting && b.hosting) || (a.account != uuid.Nil && a.account == b.account) {
+				continue
+			}
+			diff := math.Abs(a.rating - b.rating)
+			if diff > math.Min(queueGap(now.Sub(a.since)), queueGap(now.Sub(b.since))) {
+				continue
+			}
+			if diff < bestDiff {
+				best, bestDiff = j, diff
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		used[i], used[best] = true, true
+		if entries[best].hosting {
+			pairs = append(pairs, [2]queueEntry{entries[best], a})
+		} else {
+			pairs = append(pairs, [2]queueEntry{a, entries[best]})
+		}
+	}
+	return pairs
+}
+
+// pairQueue makes every match it can in one transaction. Rows another node
+// is pairing right now are skipped, not waited for.
+func (e *env) pairQueue(ctx context.Context) ([]queuePair, error) {
+	tx, err := e.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, _ := tx.Query(ctx, `
+		SELECT
+			us.user_token,
+			COALESCE(t.account_id::text, ''),
+			COALESCE(r.rating, $3),
+			us.user_status = $2,
+			COALESCE(us.queued_since, us.hosting_since, NOW()),
+			COALESCE(us.host_addr, ''),
+			us.rules,
+			COALESCE(us.board_size, 0)
+		FROM
+			user_status AS us
+			JOIN tokens AS t ON us.user_token = t.token
+			LEFT JOIN ratings AS r ON r.account_id = t.account_id
+		WHERE (us.user_status = $1 OR (us.user_status = $2 AND us.invite_code IS NULL))
+			AND NOW() - t.lastaccess < make_interval(secs => $4)
+		FOR UPDATE OF us SKIP LOCKED
+	`, "queued", "hosting", glickoRating, e.sessionIdleLimit())
+
+	var entries []queueEntry
+	var entry queueEntry
+	var tokenString, accountString string
+	_, err = pgx.ForEachRow(rows, []any{&tokenString, &accountString, &entry.rating, &entry.hosting, &entry.since, &entry.hostAddr, &entry.rules, &entry.size}, func() error {
+		entry.token, _ = uuid.Parse(tokenString)
+		// guests have none and get uuid.Nil
+		entry.account, _ = uuid.Parse(accountString)
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var made []queuePair
+	var states []*GameState
+	for _, pair := range pickPairs(entries, time.Now()) {
+		host, guest := pair[0], pair[1]
+
+		// queued players play classic on whichever node paired them
+		hostAddr, rules, size := e.cfg.AdvertiseAddr, ruleSets[defaultRules], 0
+		if host.hosting {
+			var ok bool
+			if rules, ok = rulesNamed(host.rules); !ok {
+				continue
+			}
+			hostAddr, size = host.hostAddr, host.size
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE user_status SET user_status = $1, queued_since = NULL, hosting_since = NULL WHERE user_token IN ($2, $3)", "playing", host.token.String(), guest.token.String())
+		if err != nil {
+			return nil, err
+		}
+
+		matchID, gs, err := e.startMatch(ctx, tx, host.token, guest.token, hostAddr, rules, size)
+		if err != nil {
+			return nil, err
+		}
+		made = append(made, queuePair{matchID: matchID, host: host.token, guest: guest.token, hostAddr: hostAddr})
+		states = append(states, gs)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	// other nodes pick theirs up from the database on first use, see recoverMatch
+	for i, p := range made {
+		if p.hostAddr == e.cfg.AdvertiseAddr {
+			e.matches.Store(p.matchID, &Match{HostToken: p.host, GuestToken: p.guest, GameState: states[i]})
+		}
+	}
+	return made, nil
+}
+
+// runMatcher pairs the queue every queueInterval until ctx is done. Every
+// node runs one, SKIP LOCKED keeps them out of each other's way.
+func (e *env) runMatcher(ctx context.Context) {
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if e.draining.Load() {
+			continue
+		}
+		made, err := e.pairQueue(ctx)
+		if err != nil {
+			log.Printf("Matcher: %v\n", err)
+			continue
+		}
+		if len(made) > 0 {
+			log.Printf("Matcher paired %d matches\n", len(made))
+		}
+	}
+}
+
+// joinQueue queues the user and tries to pair them straight away. 200 or 302
+// with the match like a join when that works, 202 when they have to wait;
+// then /game/match finds the match once there is one.
+func (e *env) joinQueue(c *gin.Context) {
+	token := c.MustGet("token").(uuid.UUID)
+
+	tag, err := e.db.Exec(context.Background(), "UPDATE user_status SET user_status = $1, queued_since = NOW() WHERE user_token = $2 AND user_status = $3", "queued", token.String(), "idle")
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		respondError(c, ErrUserNotIdle)
+		return
+	}
+
+	made, err := e.pairQueue(context.Background())
+	if err != nil {
+		// still queued, the matcher will get to it
+		log.Printf("Pairing on join: %v\n", err)
+	}
+
+	for _, p := range made {
+		if p.host != token && p.guest != token {
+			continue
+		}
+		if p.hostAddr != e.cfg.AdvertiseAddr {
+			c.IndentedJSON(http.StatusFound, gin.H{"message": "redirect requests to host server", "location": "http://" + p.hostAddr, "matchID": p.matchID.String()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "successfully joined game", "matchID": p.matchID.String()})
+		return
+	}
+
+	c.IndentedJSON(http.StatusAccepted, gin.H{"message": "queued, waiting for an opponent"})
+}
+
+// leaveQueue takes the user out of the queue
+func (e *env) leaveQueue(c *gin.Context) {
+	token := c.MustGet("token").(uuid.UUID)
+
+	tag, err := e.db.Exec(context.Background(), "UPDATE user_status SET user_status = $1, queued_since = NULL WHERE user_token = $2 AND user_status = $3", "idle", token.String(), "queued")
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		respondError(c, ErrNotQueued)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "user no longer queued"})
+}
+
+// getQueue says how the user's wait is going
+func (e *env) getQueue(c *gin.Context) {
+	token := c.MustGet("token").(uuid.UUID)
+
+	var status string
+	var since *time.Time
+	err := e.db.QueryRow(context.Background(), "SELECT user_status, queued_since FROM user_status WHERE user_token = $1", token.String()).Scan(&status, &since)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondError(c, ErrNotQueued)
+		return
+	}
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	resp := queueResponse{Status: status}
+	if status == "queued" && since != nil {
+		waited := time.Since(*since)
+		resp.WaitingSeconds = int(waited.Seconds())
+		resp.Gap = queueGap(waited)
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
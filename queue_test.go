@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func queued(rating float64, waited time.Duration, now time.Time) queueEntry {
+	return queueEntry{token: uuid.New(), rating: rating, since: now.Add(-waited)}
+}
+
+func TestPickPairsNeedsBothToAccept(t *testing.T) {
+	now := time.Now()
+
+	// a has waited long enough to take a 400 gap, b only takes queueBaseGap
+	a := queued(1500, 60*time.Second, now)
+	b := queued(1900, 0, now)
+	if pairs := pickPairs([]queueEntry{a, b}, now); len(pairs) != 0 {
+		t.Fatalf("paired %v and %v, b doesn't accept the gap yet", pairs[0][0].rating, pairs[0][1].rating)
+	}
+
+	b.since = now.Add(-60 * time.Second)
+	if pairs := pickPairs([]queueEntry{a, b}, now); len(pairs) != 1 {
+		t.Fatalf("got %d pairs once both waited, want 1", len(pairs))
+	}
+}
+
+func TestPickPairsClosestRating(t *testing.T) {
+	now := time.Now()
+	a := queued(1500, 10*time.Second, now)
+	far := queued(1580, 5*time.Second, now)
+	near := queued(1510, 5*time.Second, now)
+
+	pairs := pickPairs([]queueEntry{far, a, near}, now)
+	if len(pairs) != 1 {
+		t.Fatalf("got %d pairs, want 1", len(pairs))
+	}
+	if pairs[0][0].token != a.token || pairs[0][1].token != near.token {
+		t.Fatalf("a was paired with %v, want the closer rating", pairs[0][1].rating)
+	}
+}
+
+func TestPickPairsHosts(t *testing.T) {
+	now := time.Now()
+	h1 := queued(1500, 0, now)
+	h1.hosting = true
+	h2 := queued(1500, 0, now)
+	h2.hosting = true
+	if pairs := pickPairs([]queueEntry{h1, h2}, now); len(pairs) != 0 {
+		t.Fatal("two hosts were paired")
+	}
+
+	// the lobby host hosts even when the other waited longer
+	p := queued(1500, time.Minute, now)
+	pairs := pickPairs([]queueEntry{h1, p}, now)
+	if len(pairs) != 1 || pairs[0][0].token != h1.token {
+		t.Fatalf("got %v, want h1 hosting", pairs)
+	}
+}
+
+func TestPickPairsSameAccount(t *testing.T) {
+	now := time.Now()
+	a := queued(1500, 0, now)
+	a.account = uuid.New()
+	b := queued(1500, 0, now)
+	b.account = a.account
+	if pairs := pickPairs([]queueEntry{a, b}, now); len(pairs) != 0 {
+		t.Fatal("two sessions of one account were paired")
+	}
+
+	// guests all have uuid.Nil, that doesn't make them the same player
+	if pairs := pickPairs([]queueEntry{queued(1500, 0, now), queued(1500, 0, now)}, now); len(pairs) != 1 {
+		t.Fatalf("got %d pairs of guests, want 1", len(pairs))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Ratings are kept by account since tokens come and go, so only games
+// between two accounts are rated. See glicko.go for the maths.
+
+// RatingView is a player's current rating and their latest changes
+type RatingView struct {
+	Username   string        `json:"username"`
+	Rating     float64       `json:"rating"`
+	Deviation  float64       `json:"deviation"`
+	Volatility float64       `json:"volatility"`
+	Games      int           `json:"games"`
+	History    []RatingPoint `json:"history"`
+}
+
+// RatingPoint is a rating right after a game
+type RatingPoint struct {
+	GameID    string    `json:"game_id"`
+	Rating    float64   `json:"rating"`
+	Deviation float64   `json:"deviation"`
+	At        time.Time `json:"at"`
+}
+
+// how many RatingPoints getRating sends
+const ratingHistoryLength = 20
+
+// updateRatings rates a finished game between two accounts in tx, both
+// ratings as they were before it
+func (e *env) updateRatings(ctx context.Context, tx pgx.Tx, gameID uuid.UUID, winner, loser uuid.UUID) error {
+	// both rows are created and locked in account order, so two games ending
+	// at once with the same players can't deadlock
+	ids := []uuid.UUID{winner, loser}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	for _, id := range ids {
+		_, err := tx.Exec(ctx, "INSERT INTO ratings (account_id, rating, deviation, volatility) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+			id.String(), glickoRating, glickoDeviation, glickoVolatility)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, _ := tx.Query(ctx, "SELECT account_id, rating, deviation, volatility FROM ratings WHERE account_id IN ($1, $2) ORDER BY account_id FOR UPDATE", ids[0].String(), ids[1].String())
+	before := map[uuid.UUID]glicko{}
+	var idString string
+	var r glicko
+	_, err := pgx.ForEachRow(rows, []any{&idString, &r.Rating, &r.Deviation, &r.Volatility}, func() error {
+		id, err := uuid.Parse(idString)
+		if err != nil {
+			return err
+		}
+		before[id] = r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	after := map[uuid.UUID]glicko{
+		winner: before[winner].update(before[loser], 1),
+		loser:  before[loser].update(before[winner], 0),
+	}
+
+	for _, id := range ids {
+		r := after[id]
+		_, err = tx.Exec(ctx, "UPDATE ratings SET rating = $2, deviation = $3, volatility = $4, games = games + 1 WHERE account_id = $1", id.String(), r.Rating, r.Deviation, r.Volatility)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO rating_history (account_id, game_id, rating, deviation, volatility) VALUES ($1, $2, $3, $4, $5)", id.String(), gameID.String(), r.Rating, r.Deviation, r.Volatility)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *env) getRating(c *gin.Context) {
+	view := RatingView{Username: c.Param("username"), History: []RatingPoint{}}
+
+	var accountID string
+	err := e.db.QueryRow(context.Background(), "SELECT r.account_id, r.rating, r.deviation, r.volatility, r.games FROM ratings AS r JOIN accounts AS a ON r.account_id = a.account_id WHERE a.username = $1", view.Username).Scan(&accountID, &view.Rating, &view.Deviation, &view.Volatility, &view.Games)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondError(c, ErrNotRated)
+		return
+	}
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	rows, _ := e.db.Query(context.Background(), "SELECT game_id, rating, deviation, at FROM rating_history WHERE account_id = $1 ORDER BY at DESC LIMIT $2", accountID, ratingHistoryLength)
+	var p RatingPoint
+	_, err = pgx.ForEachRow(rows, []any{&p.GameID, &p.Rating, &p.Deviation, &p.At}, func() error {
+		view.History = append(view.History, p)
+		return nil
+	})
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, view)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// The reaper ends sessions nobody has used for session-ttl. Their tokens have
+// expired by then, but the rows stay behind and keep dead hosts in the lobby
+// and queue. Every node runs one; a session in a live match is reaped by the
+// node hosting it, which forfeits the match first. Matches left on a node
+// that's gone are adopted by whichever reaper gets there first.
+
+const reapInterval = time.Minute
+
+// sessionIdleLimit is how long a session can go untouched before it's dead.
+// lastaccess is only written every sessionTouchInterval, so that's added on.
+func (e *env) sessionIdleLimit() float64 {
+	return (e.cfg.SessionTTL + sessionTouchInterval).Seconds()
+}
+
+type staleSession struct {
+	token    uuid.UUID
+	username string
+	status   string
+	gameID   uuid.UUID
+	gameAddr string
+}
+
+// reapSessions removes every idle session it can and returns how many
+func (e *env) reapSessions(ctx context.Context) (int, error) {
+	rows, _ := e.db.Query(ctx, `
+		SELECT
+			t.token,
+			t.username,
+			COALESCE(us.user_status, ''),
+			COALESCE(g.game_id::text, ''),
+			COALESCE(g.host_addr, '')
+		FROM
+			tokens AS t
+			LEFT JOIN user_status AS us ON us.user_token = t.token
+			LEFT JOIN games AS g ON g.game_id = us.game_id
+		WHERE NOW() - t.lastaccess > make_interval(secs => $1)
+	`, e.sessionIdleLimit())
+
+	var stale []staleSession
+	var s staleSession
+	var tokenString, gameIDString string
+	_, err := pgx.ForEachRow(rows, []any{&tokenString, &s.username, &s.status, &gameIDString, &s.gameAddr}, func() error {
+		s.token, _ = uuid.Parse(tokenString)
+		s.gameID, _ = uuid.Parse(gameIDString)
+		stale = append(stale, s)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	for _, s := range stale {
+		if s.gameID != uuid.Nil {
+			// deleting the token takes the games row with it, the hosting
+			// node has to finish the match first
+			if s.gameAddr != e.cfg.AdvertiseAddr {
+				continue
+			}
+			if err := e.forfeitMatch(ctx, s.gameID, s.token); err != nil {
+				log.Printf("Reaper: could not forfeit match %s for %s: %v\n", s.gameID, s.username, err)
+				continue
+			}
+		}
+
+		// they may have come back since the select
+		tag, err := e.db.Exec(ctx, "DELETE FROM tokens WHERE token = $1 AND NOW() - lastaccess > make_interval(secs => $2)", s.token.String(), e.sessionIdleLimit())
+		if err != nil {
+			return reaped, err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		e.touched.Delete(s.token)
+		reaped++
+		if s.gameID != uuid.Nil {
+			log.Printf("Reaped session %s (%s), %s, forfeited match %s\n", s.token, s.username, s.status, s.gameID)
+		} else {
+			log.Printf("Reaped session %s (%s), %s\n", s.token, s.username, s.status)
+		}
+	}
+	return reaped, nil
+}
+
+// forfeitMatch ends a match hosted here with token giving up, see endMatch.
+// A match that's already over is left to the cleanup it has scheduled.
+func (e *env) forfeitMatch(ctx context.Context, matchID, token uuid.UUID) error {
+	match, err := e.liveMatch(ctx, matchID)
+	if err != nil {
+		return err
+	}
+
+	loser := Host
+	if token == match.GuestToken {
+		loser = Guest
+	}
+	err = e.endMatch(ctx, matchID, match, loser)
+	if errors.Is(err, ErrGameOver) {
+		return nil
+	}
+	return err
+}
+
+// liveMatch is a match hosted here, loaded from the database if need be
+func (e *env) liveMatch(ctx context.Context, matchID uuid.UUID) (*Match, error) {
+	if m, ok := e.matches.Load(matchID); ok {
+		return m.(*Match), nil
+	}
+	return e.restoreMatch(ctx, matchID)
+}
+
+// endMatch forfeits the match for loser. saveMove sends both players back to
+// idle, the match stays for finishedMatchTTL so the other one sees they won. A match that's already over is
+// ErrGameOver and left alone.
+func (e *env) endMatch(ctx context.Context, matchID uuid.UUID, match *Match, loser PlayerType) error {
+	match.mu.Lock()
+	defer match.mu.Unlock()
+
+	evs, err := match.GameState.forfeit(loser)
+	if err != nil {
+		return err
+	}
+
+	if err := e.saveMove(ctx, matchID, match, evs); err != nil {
+		return err
+	}
+	if err := match.GameState.applyAll(evs); err != nil {
+		return err
+	}
+
+	e.scheduleCleanup(matchID)
+	return nil
+}
+
+// adoptMatches takes over matches whose node is gone, shut down or hasn't
+// had a heartbeat for hostDeadAfter, so they can be finished and their players reaped. It returns how many it took.
+func (e *env) adoptMatches(ctx context.Context) (int, error) {
+	rows, _ := e.db.Query(ctx, `
+		SELECT
+			g.game_id::text,
+			COALESCE(g.host_addr, '')
+		FROM
+			games AS g
+			LEFT JOIN hosts AS h ON h.host_addr = g.host_addr
+		WHERE
+			g.host_addr IS DISTINCT FROM $2
+			AND (h.host_addr IS NULL OR h.status <> $1 OR NOW() - h.last_seen > make_interval(secs => $3))
+	`, hostActive, e.cfg.AdvertiseAddr, hostDeadAfter.Seconds())
+
+	orphans := map[uuid.UUID]string{}
+	var gameIDString, hostAddr string
+	_, err := pgx.ForEachRow(rows, []any{&gameIDString, &hostAddr}, func() error {
+		if id, err := uuid.Parse(gameIDString); err == nil {
+			orphans[id] = hostAddr
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	adopted := 0
+	for matchID, hostAddr := range orphans {
+		// only if it's still there, a draining node may be handing it off
+		tag, err := e.db.Exec(ctx, "UPDATE games SET host_addr = $1 WHERE game_id = $2 AND host_addr IS NOT DISTINCT FROM NULLIF($3, '')", e.cfg.AdvertiseAddr, matchID.String(), hostAddr)
+		if err != nil {
+			return adopted, err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		// a stale copy from before we crashed would be missing moves
+		e.matches.Delete(matchID)
+		if _, err := e.restoreMatch(ctx, matchID); err != nil {
+			log.Printf("Reaper: could not load match %s from %s: %v\n", matchID, hostAddr, err)
+			continue
+		}
+		adopted++
+		log.Printf("Adopted match %s from %s\n", matchID, hostAddr)
+	}
+	return adopted, nil
+}
+
+// runReaper calls reapSessions every reapInterval until ctx is done
+func (e *env) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := e.heartbeat(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Reaper: heartbeat: %v\n", err)
+		}
+		if e.draining.Load() {
+			continue
+		}
+		if _, err := e.adoptMatches(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Reaper: %v\n", err)
+		}
+		reaped, err := e.reapSessions(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Reaper: %v\n", err)
+		}
+		if reaped > 0 {
+			log.Printf("Reaper removed %d sessions\n", reaped)
+		}
+	}
+}
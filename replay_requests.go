@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ReplayView is a game as it stood after Move shots. Finished games come with
+// both boards and the log, live ones only with the asking player's board.
+type ReplayView struct {
+	GameID     string             `json:"game_id"`
+	Move       int                `json:"move"`
+	TotalMoves int                `json:"totalMoves"`
+	Finished   bool               `json:"finished"`
+	Full       *FullGameState     `json:"full,omitempty"`
+	Censored   *CensoredGameState `json:"censored,omitempty"`
+	Events     []Event            `json:"events,omitempty"`
+}
+
+// getReplay rebuilds a game from its log, at ?move=N or the latest move.
+// Works on any node, the log is in the database.
+func (e *env) getReplay(c *gin.Context) {
+	userToken := c.MustGet("token").(uuid.UUID)
+
+	matchID, err := uuid.Parse(c.Param("game_id"))
+	if err != nil {
+		respondError(c, ErrMalformedMatchID)
+		return
+	}
+
+	evs, err := e.loadEvents(context.Background(), matchID)
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	if len(evs) == 0 {
+		respondError(c, ErrGameNotFound)
+		return
+	}
+
+	final, err := replay(evs, -1)
+	if err != nil {
+		respondError(c, ErrCorruptGame.withDetail(err.Error()))
+		return
+	}
+
+	total := len(final.moves)
+	move := total
+	if moveString, exists := c.GetQuery("move"); exists {
+		move, err = strconv.Atoi(moveString)
+		if err != nil || move < 0 || move > total {
+			respondError(c, ErrBadMoveNumber.withDetail("0 to "+strconv.Itoa(total)))
+			return
+		}
+	}
+
+	gs, err := replay(evs, move)
+	if err != nil {
+		respondError(c, ErrCorruptGame.withDetail(err.Error()))
+		return
+	}
+
+	view := ReplayView{GameID: matchID.String(), Move: move, TotalMoves: total}
+
+	// nothing left to hide once it's over
+	if final.winner != NoneWinner {
+		view.Finished = true
+		view.Full = gs.toFull()
+		view.Events = gs.events
+		c.IndentedJSON(http.StatusOK, view)
+		return
+	}
+
+	p, err := e.playerInGame(context.Background(), matchID, userToken)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	view.Censored = gs.toCensored(p)
+	c.IndentedJSON(http.StatusOK, view)
+}
+
+// playerInGame tells which side token plays on in a live game
+func (e *env) playerInGame(ctx context.Context, matchID, token uuid.UUID) (PlayerType, error) {
+	var hostTokenString, guestTokenString string
+	err := e.db.QueryRow(ctx, "SELECT player_one, player_two FROM games WHERE game_id = $1", matchID.String()).Scan(&hostTokenString, &guestTokenString)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NoneWinner, ErrNotInMatch
+	}
+	if err != nil {
+		return NoneWinner, ErrSQL
+	}
+
+	switch token.String() {
+	case hostTokenString:
+		return Host, nil
+	case guestTokenString:
+		return Guest, nil
+	}
+	return NoneWinner, ErrNotInMatch
+}
+
+// exportReplay sends a finished game in notation, see notation.go. Anyone
+// can see a finished game in full already, so there's no player check.
+func (e *env) exportReplay(c *gin.Context) {
+	matchID, err := uuid.Parse(c.Param("game_id"))
+	if err != nil {
+		respondError(c, ErrMalformedMatchID)
+		return
+	}
+
+	evs, err := e.loadEvents(context.Background(), matchID)
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	if len(evs) == 0 {
+		respondError(c, ErrGameNotFound)
+		return
+	}
+
+	final, err := replay(evs, -1)
+	if err != nil {
+		respondError(c, ErrCorruptGame.withDetail(err.Error()))
+		return
+	}
+
+	if final.winner == NoneWinner {
+		respondError(c, ErrMatchNotOver)
+		return
+	}
+
+	text, err := formatNotation(matchID.String(), evs)
+	if err != nil {
+		respondError(c, ErrCorruptGame.withDetail(err.Error()))
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+matchID.String()+`.bgn"`)
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+}
+
+// importReplay plays a game in notation through the rules and answers with
+// the result. Nothing is stored, it's for viewing and checking shared games.
+func (e *env) importReplay(c *gin.Context) {
+	text, exists := c.GetPostForm("notation")
+	if !exists || text == "" {
+		respondError(c, ErrMissingNotation)
+		return
+	}
+
+	ng, err := parseNotation(text)
+	if err != nil {
+		respondError(c, ErrInvalidNotation.withDetail(err.Error()))
+		return
+	}
+
+	evs, err := ng.toEvents()
+	if err != nil {
+		respondError(c, ErrInvalidNotation.withDetail(err.Error()))
+		return
+	}
+
+	gs, err := replay(evs, -1)
+	if err != nil {
+		respondError(c, ErrInvalidNotation.withDetail(err.Error()))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, ReplayView{
+		GameID:     ng.Tags["Game"],
+		Move:       len(gs.moves),
+		TotalMoves: len(gs.moves),
+		Finished:   gs.winner != NoneWinner,
+		Full:       gs.toFull(),
+		Events:     gs.events,
+	})
+}
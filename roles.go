@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Accounts have a role, player unless an admin says otherwise. Guests have
+// none. Roles are read from accounts on every privileged request rather than
+// put in the token, so a demotion counts straight away.
+
+const (
+	rolePlayer    = "player"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+// roleRank orders roles, each can do everything the ones below it can
+var roleRank = map[string]int{
+	rolePlayer:    1,
+	roleModerator: 2,
+	roleAdmin:     3,
+}
+
+// requireRole lets through accounts with at least role, and leaves theirs in
+// "role". Goes after userAuth.
+func (e *env) requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := c.MustGet("claims").(*sessionClaims)
+		if claims.Account == "" {
+			abortWithError(c, ErrForbidden.withDetail(role+" only"))
+			return
+		}
+
+		var have string
+		err := e.db.QueryRow(context.Background(), "SELECT role FROM accounts WHERE account_id = $1", claims.Account).Scan(&have)
+		if errors.Is(err, pgx.ErrNoRows) {
+			abortWithError(c, ErrInvalidToken)
+			return
+		}
+		if err != nil {
+			abortWithError(c, ErrSQL)
+			return
+		}
+
+		if roleRank[have] < roleRank[role] {
+			abortWithError(c, ErrForbidden.withDetail(role+" only"))
+			return
+		}
+		c.Set("role", have)
+	}
+}
+
+// setRole changes an account's role
+func (e *env) setRole(c *gin.Context) {
+	role := c.PostForm("role")
+	if _, ok := roleRank[role]; !ok {
+		respondError(c, ErrUnknownRole.withDetail("player, moderator or admin"))
+		return
+	}
+
+	username := c.Param("username")
+	tag, err := e.db.Exec(context.Background(), "UPDATE accounts SET role = $1 WHERE username = $2", role, username)
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		respondError(c, ErrAccountNotFound)
+		return
+	}
+
+	claims := c.MustGet("claims").(*sessionClaims)
+	log.Printf("%s made %s %s\n", claims.Username, username, role)
+	c.IndentedJSON(http.StatusOK, gin.H{"message": username + " is now " + role})
+}
+
+// bootstrapAdmin makes the bootstrap-admin account an admin, so there's
+// someone to hand out the other roles. The account has to exist already.
+func (e *env) bootstrapAdmin(ctx context.Context) error {
+	if e.cfg.BootstrapAdmin == "" {
+		return nil
+	}
+
+	tag, err := e.db.Exec(ctx, "UPDATE accounts SET role = $1 WHERE username = $2", roleAdmin, e.cfg.BootstrapAdmin)
+	if err != nil {
+		return err
+	}
+
+	// anyone could register a name that's still free, so there's nothing
+	// to wait for. Start without bootstrap-admin and register it first.
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("bootstrap-admin %s has no account, register it before setting bootstrap-admin", e.cfg.BootstrapAdmin)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersion is a set of public routes mounted under /<name>. A v2 gets its
+// own register function and can call straight into v1 handlers for whatever
+// didn't change, both stay mounted side by side.
+type apiVersion struct {
+	name     string
+	register func(e *env, rg *gin.RouterGroup)
+}
+
+var apiVersions = []apiVersion{
+	{"v1", registerV1},
+}
+
+// the version new clients should be using, legacy routes point here
+const currentAPIVersion = "v1"
+
+// legacyRoutes maps "METHOD /legacy/path" to the versioned path replacing it
+var legacyRoutes = map[string]string{}
+
+func registerV1(e *env, rg *gin.RouterGroup) {
+	rg.POST("/user/:username", e.postUsers)
+	rg.POST("/accounts", e.postAccount)
+	rg.POST("/login", e.login)
+	rg.POST("/extendSession", e.userAuth, e.extendSessionRequest)
+	rg.POST("/logout", e.userAuth, e.logout)
+	rg.GET("/sessions", e.userAuth, e.getSessions)
+	rg.DELETE("/sessions/:session_id", e.userAuth, e.deleteSession)
+	rg.POST("/joinMatch", e.notDraining, e.userAuth, e.joinMatch)
+	rg.POST("/hostMatch", e.notDraining, e.userAuth, e.hostMatch)
+	rg.DELETE("/hostMatch", e.userAuth, e.unhostMatch)
+	rg.POST("/queue", e.notDraining, e.userAuth, e.joinQueue)
+	rg.GET("/queue", e.userAuth, e.getQueue)
+	rg.DELETE("/queue", e.userAuth, e.leaveQueue)
+	rg.GET("/lobby", e.getLobby)
+	rg.POST("/lobby/:username", e.notDraining, e.userAuth, e.joinHost)
+	rg.POST("/invite/:code", e.notDraining, e.userAuth, e.joinInvite)
+	rg.POST("/replay/import", e.importReplay)
+	rg.GET("/spectate/:match_id", e.spectate)
+	rg.GET("/rating/:username", e.getRating)
+
+	adminGroup := rg.Group("/admin", e.userAuth)
+	{
+		adminGroup.PUT("/accounts/:username/role", e.requireRole(roleAdmin), e.setRole)
+		adminGroup.GET("/matches", e.requireRole(roleModerator), e.adminMatches)
+		adminGroup.GET("/matches/:match_id", e.requireRole(roleModerator), e.adminMatch, e.adminInspect)
+		adminGroup.POST("/matches/:match_id/end", e.requireRole(roleAdmin), e.adminMatch, e.adminEndMatch)
+		adminGroup.POST("/users/:username/kick", e.requireRole(roleModerator), e.adminKick)
+	}
+
+	gameGroup := rg.Group("/game", e.userAuth)
+	{
+		gameGroup.GET("/match", e.getMatch)
+		gameGroup.GET("/play", e.playAuth, e.getGameState)
+		gameGroup.POST("/play", e.playAuth, e.postMove)
+		gameGroup.GET("/replay/:game_id", e.getReplay)
+		gameGroup.GET("/replay/:game_id/export", e.exportReplay)
+		// TODO: forfeit function
+	}
+}
+
+// registerLegacy keeps the pre-versioning paths alive for deployed clients.
+// Don't add anything here, new routes only go into a version.
+func registerLegacy(e *env, router *gin.Engine) {
+	legacy := func(method, path, successor string, handlers ...gin.HandlerFunc) {
+		successor = "/" + currentAPIVersion + successor
+		legacyRoutes[method+" "+path] = successor
+		router.Handle(method, path, append([]gin.HandlerFunc{deprecated(successor)}, handlers...)...)
+	}
+
+	legacy(http.MethodPost, "/user/:username", "/user/:username", e.postUsers)
+	legacy(http.MethodPost, "/extendSession", "/extendSession", e.userAuth, e.extendSessionRequest)
+	legacy(http.MethodPost, "/joinMatch", "/joinMatch", e.notDraining, e.userAuth, e.joinMatch)
+	legacy(http.MethodPost, "/hostMatch", "/hostMatch", e.notDraining, e.userAuth, e.hostMatch)
+	legacy(http.MethodDelete, "/hostmatch", "/hostMatch", e.userAuth, e.unhostMatch)
+	legacy(http.MethodGet, "/game/match", "/game/match", e.userAuth, e.getMatch)
+	legacy(http.MethodGet, "/game/play", "/game/play", e.userAuth, e.playAuth, e.getGameState)
+	legacy(http.MethodPost, "/game/play", "/game/play", e.userAuth, e.playAuth, e.postMove)
+}
+
+// deprecated tags every response from a legacy route, errors included, so
+// clients notice before we pull the route
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// unversionedPath strips the version prefix, "/v1/game/play" -> "/game/play".
+// Legacy paths come back as their successor's.
+func unversionedPath(method, path string) string {
+	if successor, ok := legacyRoutes[method+" "+path]; ok {
+		path = successor
+	}
+	for _, v := range apiVersions {
+		if rest, ok := strings.CutPrefix(path, "/"+v.name+"/"); ok {
+			return "/" + rest
+		}
+	}
+	return path
+}
+
+// routeVersion is "v1" etc, "legacy", or "" for unversioned routes like /internal
+func routeVersion(method, path string) string {
+	if _, ok := legacyRoutes[method+" "+path]; ok {
+		return "legacy"
+	}
+	for _, v := range apiVersions {
+		if strings.HasPrefix(path, "/"+v.name+"/") {
+			return v.name
+		}
+	}
+	return ""
+}
+
+func registerRoutes(e *env, router *gin.Engine) {
+	for _, v := range apiVersions {
+		v.register(e, router.Group("/"+v.name))
+	}
+	registerLegacy(e, router)
+
+	// node to node, versioned with the binary rather than the API
+	internalGroup := router.Group("/internal", e.nodeAuth)
+	{
+		internalGroup.POST("/loadGame", e.notDraining, e.loadGame)
+		internalGroup.POST("/importMatch", e.notDraining, e.importMatch)
+	}
+
+	router.GET("/openapi.json", serveOpenAPI(router))
+}
@@ -0,0 +1,31 @@
+package main
+
+// Rules is a variant a host can pick. Every ship is straight and the fleet is
+// dealt at random, see newBoardFromRandom.
+type Rules struct {
+	Name        string `json:"name"`
+	ShipLengths []int  `json:"shipLengths"` // in squares
+}
+
+const defaultRules = "classic"
+
+// board sizes a host can ask for, both sides square
+const (
+	minBoardSize = 8
+	maxBoardSize = 12
+)
+
+var ruleSets = map[string]Rules{
+	"classic": {Name: "classic", ShipLengths: []int{4, 4, 4}},
+	"fleet":   {Name: "fleet", ShipLengths: []int{5, 4, 3, 3, 2}},
+}
+
+// rulesNamed looks up a rule set, empty is the default. Games from before
+// there were rules have none in their created event.
+func rulesNamed(name string) (Rules, bool) {
+	if name == "" {
+		name = defaultRules
+	}
+	r, ok := ruleSets[name]
+	return r, ok
+}
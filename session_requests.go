@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// SessionView is one of an account's sessions
+type SessionView struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	LastAccess time.Time `json:"lastAccess"`
+	Current    bool      `json:"current"`
+}
+
+type sessionsResponse struct {
+	Sessions []SessionView `json:"sessions"`
+}
+
+// endSession ends sid for good. Hosting and queueing go with the session, a
+// live match is forfeited first. That has to happen on the node hosting the
+// match, so if that's another node its address comes back and nothing is done.
+func (e *env) endSession(ctx context.Context, sid uuid.UUID) (string, error) {
+	var gameIDString, gameAddr string
+	err := e.db.QueryRow(ctx, `
+		SELECT
+			COALESCE(g.game_id::text, ''),
+			COALESCE(g.host_addr, '')
+		FROM
+			tokens AS t
+			LEFT JOIN user_status AS us ON us.user_token = t.token
+			LEFT JOIN games AS g ON g.game_id = us.game_id
+		WHERE t.token = $1
+	`, sid.String()).Scan(&gameIDString, &gameAddr)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// already gone, make sure its tokens are too
+		if err := e.revokeSession(ctx, sid); err != nil {
+			return "", ErrSQL
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", ErrSQL
+	}
+
+	if gameIDString != "" {
+		if gameAddr != e.cfg.AdvertiseAddr {
+			return gameAddr, nil
+		}
+		gameID, _ := uuid.Parse(gameIDString)
+		if err := e.forfeitMatch(ctx, gameID, sid); err != nil {
+			return "", ErrSQL
+		}
+	}
+
+	// user_status goes with the token, which takes it out of the lobby and queue
+	return "", e.RemoveUser(sid)
+}
+
+// respondSessionEnded answers for endSession, redirecting to the match's
+// node when it has to be done there
+func respondSessionEnded(c *gin.Context, hostAddr string, err error, message string) {
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if hostAddr != "" {
+		c.IndentedJSON(http.StatusFound, gin.H{"message": "in a match, redirect requests to host server", "location": "http://" + hostAddr})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"message": message})
+}
+
+// logout ends the caller's session, forfeiting any match they're in
+func (e *env) logout(c *gin.Context) {
+	token := c.MustGet("token").(uuid.UUID)
+	hostAddr, err := e.endSession(context.Background(), token)
+	respondSessionEnded(c, hostAddr, err, "logged out")
+}
+
+// accountID is the caller's account, ErrNotAccount for guests
+func accountID(c *gin.Context) (uuid.UUID, error) {
+	claims := c.MustGet("claims").(*sessionClaims)
+	id, err := uuid.Parse(claims.Account)
+	if err != nil {
+		return uuid.Nil, ErrNotAccount
+	}
+	return id, nil
+}
+
+// getSessions lists the account's live sessions, most recently used first
+func (e *env) getSessions(c *gin.Context) {
+	account, err := accountID(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	token := c.MustGet("token").(uuid.UUID)
+
+	rows, _ := e.db.Query(context.Background(), `
+		SELECT
+			t.token,
+			COALESCE(us.user_status, ''),
+			t.lastaccess
+		FROM
+			tokens AS t
+			LEFT JOIN user_status AS us ON us.user_token = t.token
+		WHERE t.account_id = $1
+		ORDER BY t.lastaccess DESC
+	`, account.String())
+
+	sessions := []SessionView{}
+	var s SessionView
+	_, err = pgx.ForEachRow(rows, []any{&s.ID, &s.Status, &s.LastAccess}, func() error {
+		s.Current = s.ID == token.String()
+		sessions = append(sessions, s)
+		return nil
+	})
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, sessionsResponse{Sessions: sessions})
+}
+
+// deleteSession ends one of the account's sessions, the caller's own included
+func (e *env) deleteSession(c *gin.Context) {
+	account, err := accountID(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	sid, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		respondError(c, ErrSessionNotFound)
+		return
+	}
+
+	var owned bool
+	err = e.db.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM tokens WHERE token = $1 AND account_id = $2)", sid.String(), account.String()).Scan(&owned)
+	if err != nil {
+		respondError(c, ErrSQL)
+		return
+	}
+	if !owned {
+		respondError(c, ErrSessionNotFound)
+		return
+	}
+
+	hostAddr, err := e.endSession(context.Background(), sid)
+	respondSessionEnded(c, hostAddr, err, "session ended")
+}
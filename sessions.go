@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Session tokens are signed claims, base64url(JSON) "." base64url(HMAC-SHA256),
+// keyed with session-key which every node shares. userAuth checks them
+// without the database. The session id is the tokens row, so everything keyed
+// by token works as before. Revoked sessions are kept in revoked_sessions
+// until their tokens would have expired anyway, and every node polls that
+// table into memory.
+
+// sessionTouchInterval is how often tokens.lastaccess is written for a live
+// session. The lobby and queue only need to know who's still around.
+const sessionTouchInterval = time.Minute
+
+// sessionTokenHeader carries a renewed token on responses to requests whose
+// token is past half its life
+const sessionTokenHeader = "Session-Token"
+
+type sessionClaims struct {
+	Session  uuid.UUID `json:"sid"`
+	Username string    `json:"sub"`
+	Account  string    `json:"acc,omitempty"` // empty for guests
+	Expires  int64     `json:"exp"`
+}
+
+// revocations is this node's copy of revoked_sessions
+type revocations struct {
+	mu  sync.RWMutex
+	ids map[uuid.UUID]time.Time
+}
+
+func (r *revocations) has(id uuid.UUID) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.ids[id]
+	return ok
+}
+
+func (r *revocations) add(id uuid.UUID, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ids == nil {
+		r.ids = map[uuid.UUID]time.Time{}
+	}
+	r.ids[id] = until
+}
+
+// replace swaps in a fresh load, keeping local additions the load may have
+// missed
+func (r *revocations) replace(ids map[uuid.UUID]time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, until := range r.ids {
+		if _, ok := ids[id]; !ok && until.After(now) {
+			ids[id] = until
+		}
+	}
+	r.ids = ids
+}
+
+// issueToken signs a token for a session, good for session-ttl
+func (e *env) issueToken(sid uuid.UUID, username string, accountID uuid.UUID) string {
+	claims := sessionClaims{Session: sid, Username: username, Expires: time.Now().Add(e.cfg.SessionTTL).Unix()}
+	if accountID != uuid.Nil {
+		claims.Account = accountID.String()
+	}
+	payload, _ := json.Marshal(claims)
+
+	b64 := base64.RawURLEncoding
+	body := b64.EncodeToString(payload)
+	return body + "." + b64.EncodeToString(e.signToken(body))
+}
+
+func (e *env) signToken(body string) []byte {
+	mac := hmac.New(sha256.New, []byte(e.cfg.SessionKey))
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
+
+// parseToken checks a token's signature, expiry and revocation
+func (e *env) parseToken(token string) (*sessionClaims, error) {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrMalformedToken
+	}
+
+	b64 := base64.RawURLEncoding
+	gotSig, err := b64.DecodeString(sig)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if !hmac.Equal(gotSig, e.signToken(body)) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := b64.DecodeString(body)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	claims := &sessionClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if time.Now().Unix() >= claims.Expires {
+		return nil, ErrExpiredToken
+	}
+	if e.revoked.has(claims.Session) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// touchSession keeps tokens.lastaccess roughly current, at most one write per
+// session every sessionTouchInterval from this node
+func (e *env) touchSession(sid uuid.UUID) {
+	now := time.Now()
+	last, ok := e.touched.Load(sid)
+	if ok && now.Sub(last.(time.Time)) < sessionTouchInterval {
+		return
+	}
+	e.touched.Store(sid, now)
+
+	_, err := e.db.Exec(context.Background(), "UPDATE tokens SET lastaccess = NOW() WHERE token = $1", sid.String())
+	if err != nil {
+		log.Printf("Could not touch session %s: %v\n", sid, err)
+	}
+}
+
+// revokeSession stops every token for sid working, here now and on other
+// nodes from their next poll
+func (e *env) revokeSession(ctx context.Context, sid uuid.UUID) error {
+	until := time.Now().Add(e.cfg.SessionTTL)
+	_, err := e.db.Exec(ctx, "INSERT INTO revoked_sessions (token, until) VALUES ($1, $2) ON CONFLICT (token) DO UPDATE SET until = EXCLUDED.until", sid.String(), until)
+	if err != nil {
+		return err
+	}
+	e.revoked.add(sid, until)
+	e.touched.Delete(sid)
+	return nil
+}
+
+// loadRevocations replaces the in memory list with the table, dropping rows
+// whose tokens have all expired
+func (e *env) loadRevocations(ctx context.Context) error {
+	_, err := e.db.Exec(ctx, "DELETE FROM revoked_sessions WHERE until < NOW()")
+	if err != nil {
+		return err
+	}
+
+	rows, _ := e.db.Query(ctx, "SELECT token, until FROM revoked_sessions")
+	ids := map[uuid.UUID]time.Time{}
+	var tokenString string
+	var until time.Time
+	_, err = pgx.ForEachRow(rows, []any{&tokenString, &until}, func() error {
+		if id, err := uuid.Parse(tokenString); err == nil {
+			ids[id] = until
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.revoked.replace(ids)
+	return nil
+}
+
+// pollRevocations runs loadRevocations every revocation-poll until ctx is done
+func (e *env) pollRevocations(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.RevocationPoll)
+	defer ticker.Stop()
+
+	for {
+		if err := e.loadRevocations(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Could not load revoked sessions: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
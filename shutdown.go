@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// notDraining rejects anything that would start or land a match on this node
+// once shutdown has begun
+func (e *env) notDraining(c *gin.Context) {
+	if e.draining.Load() {
+		abortWithError(c, ErrNodeDraining)
+	}
+}
+
+// shutdown drains the node: no new matches, hosting users and live matches
+// go to other nodes, in-flight requests finish. The pool is closed by main.
+func (e *env) shutdown(srv *http.Server) {
+	e.draining.Store(true)
+	log.Printf("Draining %s\n", e.cfg.AdvertiseAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.ShutdownTimeout)
+	defer cancel()
+
+	_, err := e.db.Exec(ctx, "UPDATE hosts SET status = $1 WHERE host_addr = $2", hostDraining, e.cfg.AdvertiseAddr)
+	if err != nil {
+		log.Printf("Could not mark host as draining: %v\n", err)
+	}
+
+	e.handOffHosting(ctx)
+
+	// after this nothing touches e.matches but us
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown: %v\n", err)
+	}
+
+	e.handOffMatches(ctx)
+}
+
+// handOffHosting moves users hosting on this node elsewhere, so joins don't
+// try to start their match here
+func (e *env) handOffHosting(ctx context.Context) {
+	target, err := e.pickNode(ctx)
+	if err != nil {
+		log.Printf("Could not pick a node for hosting users: %v\n", err)
+		return
+	}
+
+	if target == "" {
+		_, err = e.db.Exec(ctx, "UPDATE user_status SET user_status = $1, host_addr = NULL WHERE host_addr = $2 AND user_status = $3", "idle", e.cfg.AdvertiseAddr, "hosting")
+	} else {
+		_, err = e.db.Exec(ctx, "UPDATE user_status SET host_addr = $1 WHERE host_addr = $2 AND user_status = $3", target, e.cfg.AdvertiseAddr, "hosting")
+	}
+	if err != nil {
+		log.Printf("Could not move hosting users: %v\n", err)
+	}
+}
+
+// handOffMatches sends every live match to another node and points its games
+// row there, players find it again through /game/match. A match that can't
+// go anywhere is ended unrated, so its players aren't stuck playing it.
+func (e *env) handOffMatches(ctx context.Context) {
+	var moved, lost int
+	e.matches.Range(func(key, value any) bool {
+		matchID := key.(uuid.UUID)
+		match := value.(*Match)
+		if err := e.handOffMatch(ctx, matchID, match); err != nil {
+			log.Printf("Lost match %s: %v\n", matchID, err)
+			lost++
+			err = e.abandonMatch(ctx, matchID, match)
+			if errors.Is(err, ErrGameOver) {
+				e.matchCleanup(matchID)
+			} else if err != nil {
+				log.Printf("Could not end match %s: %v\n", matchID, err)
+			}
+		} else {
+			moved++
+		}
+		e.matches.Delete(key)
+		return true
+	})
+	log.Printf("Handed off %d matches, lost %d\n", moved, lost)
+}
+
+func (e *env) handOffMatch(ctx context.Context, matchID uuid.UUID, match *Match) error {
+	target, err := e.pickNode(ctx)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return errNoNodes
+	}
+
+	// no moves while it's in flight, the ones waiting are redirected after
+	match.mu.Lock()
+	defer match.mu.Unlock()
+
+	raw, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+
+	// if it doesn't take it, the target rebuilds it from game_events once
+	// someone asks for it, see recoverMatch
+	err = e.callNode(ctx, target, "/importMatch", url.Values{"game_id": {matchID.String()}, "match": {string(raw)}})
+	if err != nil {
+		log.Printf("Could not send match %s to %s, it'll load it from disk: %v\n", matchID, target, err)
+	}
+
+	tag, err := e.db.Exec(ctx, "UPDATE games SET host_addr = $1 WHERE game_id = $2 AND host_addr = $3", target, matchID.String(), e.cfg.AdvertiseAddr)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// a reaper elsewhere adopted it already, see adoptMatches
+		log.Printf("Match %s was taken over while handing it off\n", matchID)
+		return nil
+	}
+	match.movedTo = target
+	return nil
+}
+
+// importMatch takes a live match from a draining node
+func (e *env) importMatch(c *gin.Context) {
+	gameID, exists := c.GetPostForm("game_id")
+	if gameID == "" || !exists {
+		respondError(c, ErrMissingGameID)
+		return
+	}
+
+	matchID, err := uuid.Parse(gameID)
+	if err != nil {
+		respondError(c, ErrMalformedMatchID)
+		return
+	}
+
+	match := &Match{}
+	if err := json.Unmarshal([]byte(c.PostForm("match")), match); err != nil || match.GameState == nil {
+		respondError(c, ErrBadMatchImport)
+		return
+	}
+
+	e.matches.Store(matchID, match)
+	if match.GameState.winner != NoneWinner {
+		e.scheduleCleanup(matchID)
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "match successfully stored in memory"})
+}
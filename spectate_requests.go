@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// SpectatorGameState is a live game for someone who isn't playing it, both
+// boards as their opponents see them. Use gameState.toSpectator()
+type SpectatorGameState struct {
+	HostFog  []string   `json:"hostFog"`
+	GuestFog []string   `json:"guestFog"`
+	Evens    PlayerType `json:"firstPlayer"`
+	Moves    []*Move    `json:"moves"`
+	Winner   PlayerType `json:"winner"`
+}
+
+// SpectatorView is what spectate answers with, Fog while the game is on and
+// Full once it's over. AsOf is when the last thing shown happened, behind by
+// spectate-delay on a live game.
+type SpectatorView struct {
+	MatchID string              `json:"match_id"`
+	AsOf    time.Time           `json:"asOf"`
+	Fog     *SpectatorGameState `json:"fog,omitempty"`
+	Full    *FullGameState      `json:"full,omitempty"`
+}
+
+// spectate lets anyone watch a live match read only, in fog of war until it's
+// over. With spectate-delay the fog is as it stood that long ago, so nobody
+// can feed a player live.
+func (e *env) spectate(c *gin.Context) {
+	matchID, err := uuid.Parse(c.Param("match_id"))
+	if err != nil {
+		respondError(c, ErrMalformedMatchID)
+		return
+	}
+
+	var match *Match
+	matchUncast, ok := e.matches.Load(matchID)
+	if ok {
+		match = matchUncast.(*Match)
+	} else {
+		hostAddr, err := e.matchHost(context.Background(), matchID)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if hostAddr != e.cfg.AdvertiseAddr {
+			c.IndentedJSON(http.StatusFound, gin.H{"message": "redirect requests to host server", "location": "http://" + hostAddr, "match_id": matchID.String()})
+			return
+		}
+
+		match, err = e.recoverMatch(matchID)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	match.mu.Lock()
+	defer match.mu.Unlock()
+
+	evs := match.GameState.events
+	view := SpectatorView{MatchID: matchID.String(), AsOf: evs[len(evs)-1].At}
+
+	// over, nothing left to ghost
+	if match.GameState.winner != NoneWinner {
+		view.Full = match.GameState.toFull()
+		c.IndentedJSON(http.StatusOK, view)
+		return
+	}
+
+	gs := match.GameState
+	if e.cfg.SpectateDelay > 0 {
+		// events are in time order, so everything before the cutoff is a
+		// prefix. Younger than the delay, the empty boards are shown.
+		cutoff := time.Now().Add(-e.cfg.SpectateDelay)
+		shown := 3
+		for shown < len(evs) && !evs[shown].At.After(cutoff) {
+			shown++
+		}
+
+		var err error
+		gs, err = replay(evs[:shown], -1)
+		if err != nil {
+			respondError(c, ErrCorruptGame.withDetail(err.Error()))
+			return
+		}
+		view.AsOf = evs[shown-1].At
+	}
+
+	// fleets only show as they're hit, whole ones would give the game away
+	view.Fog = gs.toSpectator()
+	c.IndentedJSON(http.StatusOK, view)
+}
+
+// matchHost is the node hosting a live match
+func (e *env) matchHost(ctx context.Context, matchID uuid.UUID) (string, error) {
+	var hostAddr string
+	err := e.db.QueryRow(ctx, "SELECT host_addr FROM games WHERE game_id = $1", matchID.String()).Scan(&hostAddr)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrGameNotFound
+	}
+	if err != nil {
+		return "", ErrSQL
+	}
+	return hostAddr, nil
+}
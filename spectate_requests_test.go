@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func spectateView(t *testing.T, delay time.Duration, gs *GameState) SpectatorView {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.SpectateDelay = delay
+	e := &env{cfg: cfg, matches: &sync.Map{}}
+	matchID := uuid.New()
+	e.matches.Store(matchID, &Match{HostToken: uuid.New(), GuestToken: uuid.New(), GameState: gs})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/spectate/:match_id", e.spectate)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/spectate/"+matchID.String(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+
+	var view SpectatorView
+	if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+	return view
+}
+
+func TestSpectateHidesLiveFleets(t *testing.T) {
+	for _, delay := range []time.Duration{0, time.Nanosecond, time.Hour} {
+		gs, err := newGameStateFromSeed(3, ruleSets[defaultRules], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		view := spectateView(t, delay, gs)
+		if view.Full != nil || view.Fog == nil {
+			t.Fatalf("delay %s: live game shown in full", delay)
+		}
+		for _, row := range append(view.Fog.HostFog, view.Fog.GuestFog...) {
+			if strings.Trim(row, ".") != "" {
+				t.Fatalf("delay %s: nothing was shot yet, fog shows %q", delay, row)
+			}
+		}
+	}
+}
+
+func TestSpectateShowsFinishedGames(t *testing.T) {
+	view := spectateView(t, time.Hour, playedGame(t, 42))
+	if view.Full == nil {
+		t.Fatal("finished game not shown in full")
+	}
+}
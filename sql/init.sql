@@ -1,48 +1,175 @@
 set timezone = 'Europe/Paris';
 
+-- Safe to run again on an existing database: tables are only created when
+-- they're missing, and whatever was added since goes in with an ALTER below
+-- its table so older databases pick it up without losing anything.
+
+-- if I do busy logic, add status field here
+-- busy logic =: thread checks server status every few minutes, sets status based on metrics
+-- when user tries to host, if server is busy, redirect to random non-busy server
+CREATE TABLE IF NOT EXISTS hosts (
+    host_addr varchar(45) PRIMARY KEY
+);
+
+-- 'active' or 'draining', a draining node is shutting down and handing its
+-- matches to active ones
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS status varchar(16) NOT NULL DEFAULT 'active';
+-- every node's reaper bumps it, an active node that stops is taken as dead
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS last_seen timestamptz NOT NULL DEFAULT current_timestamp;
+
+-- registered players, password_hash is an argon2id PHC string
+CREATE TABLE IF NOT EXISTS accounts (
+    account_id uuid PRIMARY KEY,
+    username varchar(16) NOT NULL UNIQUE,
+    password_hash text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+-- skeleton of username, see usernames.go. Look-alikes share one. Accounts
+-- from before it get their own name, which at least can't clash.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS name_key varchar(64);
+UPDATE accounts SET name_key = username WHERE name_key IS NULL;
+ALTER TABLE accounts ALTER COLUMN name_key SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_name_key ON accounts(name_key);
+
+-- player, moderator or admin, see roles.go
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS role varchar(16) NOT NULL DEFAULT 'player' CHECK (role IN ('player', 'moderator', 'admin'));
+
+-- sessions. account_id is NULL for guests, an account can have several
+-- sessions so only guest names are unique here
 CREATE TABLE IF NOT EXISTS tokens (
     token uuid,
     username varchar(16) NOT NULL,
     lastaccess timestamptz NOT NULL DEFAULT current_timestamp,
     PRIMARY KEY(token),
-    UNIQUE(username),
     UNIQUE(token)
 );
 
-CREATE TABLE IF NOT EXISTS user_status (
-    user_token REFERENCES tokens(token) ON DELETE CASCADE,
-    user_status REFERENCES user_status_types(status_type),
-    game_id REFERENCES games(game_id) DEFAULT NULL ON DELETE SET NULL,
-    host_addr REFERENCES hosts(host_addr) DEFAULT NULL ON DELETE SET NULL,
-    PRIMARY KEY(user_token)
+ALTER TABLE tokens DROP CONSTRAINT IF EXISTS tokens_username_key;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS account_id uuid REFERENCES accounts(account_id) ON DELETE CASCADE DEFAULT NULL;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS name_key varchar(64);
+UPDATE tokens SET name_key = username WHERE name_key IS NULL;
+ALTER TABLE tokens ALTER COLUMN name_key SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS tokens_guest_name_key ON tokens(name_key) WHERE account_id IS NULL;
+
+-- sessions ended before their signed tokens expire, see sessions.go. Rows
+-- go once until passes, the tokens are dead by then anyway.
+CREATE TABLE IF NOT EXISTS revoked_sessions (
+    token uuid PRIMARY KEY,
+    until timestamptz NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS user_status_types (
-    status_type varchar(16)
+    status_type varchar(16) PRIMARY KEY
 );
 
-INSERT INTO user_status_types VALUES ('idle', 'hosting', 'playing');
+INSERT INTO user_status_types VALUES ('idle'), ('hosting'), ('playing'), ('queued') ON CONFLICT DO NOTHING;
 
 CREATE TABLE IF NOT EXISTS games (
     game_id uuid,
-    player_one REFERENCES tokens(token) NOT NULL ON DELETE CASCADE,
-    player_two REFERENCES tokens(token) NOT NULL ON DELETE CASCADE,
-    host_addr REFERENCES hosts(host_addr),
+    player_one uuid NOT NULL REFERENCES tokens(token) ON DELETE CASCADE,
+    player_two uuid NOT NULL REFERENCES tokens(token) ON DELETE CASCADE,
+    host_addr varchar(45) REFERENCES hosts(host_addr),
     PRIMARY KEY(game_id)
 );
 
+-- who played, as of the start. The sessions may be gone by the end, the
+-- result is recorded from these. Accounts are NULL for guests.
+ALTER TABLE games ADD COLUMN IF NOT EXISTS player_one_name varchar(16);
+ALTER TABLE games ADD COLUMN IF NOT EXISTS player_two_name varchar(16);
+ALTER TABLE games ADD COLUMN IF NOT EXISTS player_one_account uuid REFERENCES accounts(account_id) ON DELETE SET NULL DEFAULT NULL;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS player_two_account uuid REFERENCES accounts(account_id) ON DELETE SET NULL DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS user_status (
+    user_token uuid REFERENCES tokens(token) ON DELETE CASCADE,
+    user_status varchar(16) REFERENCES user_status_types(status_type),
+    game_id uuid REFERENCES games(game_id) ON DELETE SET NULL DEFAULT NULL,
+    host_addr varchar(45) REFERENCES hosts(host_addr) ON DELETE SET NULL DEFAULT NULL,
+    PRIMARY KEY(user_token)
+);
+
+-- what a hosting user wants to play, see rules.go. NULL board_size is random
+ALTER TABLE user_status ADD COLUMN IF NOT EXISTS rules varchar(16) NOT NULL DEFAULT 'classic';
+ALTER TABLE user_status ADD COLUMN IF NOT EXISTS board_size integer DEFAULT NULL;
+ALTER TABLE user_status ADD COLUMN IF NOT EXISTS hosting_since timestamptz DEFAULT NULL;
+ALTER TABLE user_status ADD COLUMN IF NOT EXISTS queued_since timestamptz DEFAULT NULL;
+-- set for private hosts, only joinable with the code
+ALTER TABLE user_status ADD COLUMN IF NOT EXISTS invite_code varchar(8) DEFAULT NULL UNIQUE;
+
+-- append only log of everything that happens in a game, see game_events.go.
+-- Not tied to games, it outlives the match for replays. It replaced
+-- game_snapshots and game_moves, which older databases keep but nothing
+-- reads anymore.
+CREATE TABLE IF NOT EXISTS game_events (
+    game_id uuid,
+    seq integer,
+    kind varchar(16) NOT NULL,
+    data jsonb NOT NULL,
+    at timestamptz NOT NULL,
+    PRIMARY KEY(game_id, seq)
+);
+
+-- player is the session token and outlives it, account_id keeps a
+-- registered player's games together across sessions
 CREATE TABLE IF NOT EXISTS game_history (
-    player REFERENCES tokens(token) ON DELETE CASCADE,
+    player uuid,
     game_id uuid,
     won boolean,
-    PRIMARY KEY(player),
-    UNIQUE(player, game_id)
+    PRIMARY KEY(player, game_id)
 );
 
--- if I do busy logic, add status field here
--- busy logic =: thread checks server status every few minutes, sets status based on metrics
--- when user tries to host, if server is busy, redirect to random non-busy server
-CREATE TABLE IF NOT EXISTS hosts (
-    host_addr varchar(45) PRIMARY KEY
+ALTER TABLE game_history ADD COLUMN IF NOT EXISTS account_id uuid REFERENCES accounts(account_id) ON DELETE CASCADE DEFAULT NULL;
+-- history used to go with the session and allow one game per player
+ALTER TABLE game_history DROP CONSTRAINT IF EXISTS game_history_player_fkey;
+ALTER TABLE game_history DROP CONSTRAINT IF EXISTS game_history_player_game_id_key;
+DO $$
+BEGIN
+    IF (SELECT count(*) FROM information_schema.key_column_usage WHERE table_name = 'game_history' AND constraint_name = 'game_history_pkey') = 1 THEN
+        ALTER TABLE game_history DROP CONSTRAINT game_history_pkey, ADD PRIMARY KEY (player, game_id);
+    END IF;
+END $$;
+
+-- ratings used to be by username, guests included. Those tables are kept
+-- under another name and their accounts' ratings carried over below.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'ratings' AND column_name = 'username') THEN
+        ALTER TABLE rating_history RENAME TO rating_history_by_username;
+        ALTER TABLE ratings RENAME TO ratings_by_username;
+    END IF;
+END $$;
+
+-- Glicko-2 ratings, accounts only, see glicko.go
+CREATE TABLE IF NOT EXISTS ratings (
+    account_id uuid PRIMARY KEY REFERENCES accounts(account_id) ON DELETE CASCADE,
+    rating double precision NOT NULL,
+    deviation double precision NOT NULL,
+    volatility double precision NOT NULL,
+    games integer NOT NULL DEFAULT 0
 );
 
+-- every rating change, one row per player per game
+CREATE TABLE IF NOT EXISTS rating_history (
+    account_id uuid REFERENCES ratings(account_id) ON DELETE CASCADE,
+    game_id uuid,
+    rating double precision NOT NULL,
+    deviation double precision NOT NULL,
+    volatility double precision NOT NULL,
+    at timestamptz NOT NULL DEFAULT current_timestamp,
+    PRIMARY KEY(account_id, game_id)
+);
+
+DO $$
+BEGIN
+    IF to_regclass('ratings_by_username') IS NOT NULL THEN
+        INSERT INTO ratings (account_id, rating, deviation, volatility, games)
+        SELECT a.account_id, r.rating, r.deviation, r.volatility, r.games
+        FROM ratings_by_username AS r JOIN accounts AS a ON a.username = r.username
+        ON CONFLICT DO NOTHING;
+
+        INSERT INTO rating_history (account_id, game_id, rating, deviation, volatility, at)
+        SELECT a.account_id, h.game_id, h.rating, h.deviation, h.volatility, h.at
+        FROM rating_history_by_username AS h JOIN accounts AS a ON a.username = h.username
+        ON CONFLICT DO NOTHING;
+    END IF;
+END $$;
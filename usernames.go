@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Usernames are shown in the public lobby, so they're held to a policy:
+// letters, digits and a few separators, from one script, of a sane length.
+// Each name also has a key, its skeleton: case, width, accents, separators
+// and look-alike characters folded away. Keys are what must be unique and
+// what the reserved list and blocklist are checked against, so "B0bby",
+// "bobby" and a fullwidth "Ｂｏｂｂｙ" are all "bobby".
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 16 // varchar(16)
+)
+
+// reservedUsernames could be mistaken for us
+var reservedUsernames = []string{
+	"admin", "administrator", "moderator", "mod", "staff", "support",
+	"official", "system", "root", "server", "internal", "api",
+	"battlego", "battlegov3", "guest", "anonymous", "spectator",
+	"null", "undefined",
+}
+
+// confusables folds characters that look like a Latin letter into it. Not
+// the whole Unicode table, the ones people actually use to impersonate.
+var confusables = map[rune]rune{
+	'0': 'o', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	// I, l and 1 look the same in plenty of fonts, and once case is folded I
+	// is i, so all four are one
+	'i': 'l', '1': 'l',
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'l', 'ј': 'j',
+	'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'һ': 'h',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+}
+
+// usernameSeparators may go between letters and digits, never at either end
+const usernameSeparators = "_-."
+
+// scripts a name's letters all have to come from one of. Japanese mixes
+// three, so they count as one.
+var usernameScripts = [][]*unicode.RangeTable{
+	{unicode.Latin},
+	{unicode.Greek},
+	{unicode.Cyrillic},
+	{unicode.Armenian},
+	{unicode.Georgian},
+	{unicode.Hebrew},
+	{unicode.Arabic},
+	{unicode.Devanagari},
+	{unicode.Thai},
+	{unicode.Hangul},
+	{unicode.Han, unicode.Hiragana, unicode.Katakana},
+}
+
+// usernameKey is the skeleton of an already checked name
+func usernameKey(name string) string {
+	folded, err := precis.UsernameCaseMapped.String(name)
+	if err != nil {
+		folded = strings.ToLower(name)
+	}
+
+	// accents only come off the alphabets the confusables are for, in other
+	// scripts marks change the letter
+	var sb strings.Builder
+	var stripMarks bool
+	for _, r := range norm.NFKD.String(folded) {
+		if strings.ContainsRune(usernameSeparators, r) {
+			continue
+		}
+		if unicode.Is(unicode.Mn, r) {
+			if !stripMarks {
+				sb.WriteRune(r)
+			}
+			continue
+		}
+		stripMarks = unicode.In(r, unicode.Latin, unicode.Greek, unicode.Cyrillic)
+		if f, ok := confusables[r]; ok {
+			r = f
+		}
+		sb.WriteRune(r)
+	}
+	return norm.NFC.String(strings.ReplaceAll(sb.String(), "rn", "m"))
+}
+
+type usernamePolicy struct {
+	reserved map[string]bool
+	blocked  []string
+}
+
+// newUsernamePolicy reads the blocklist at path, one word per line, # for
+// comments. Blocked words are matched anywhere in a name's key. Empty path
+// means no blocklist.
+func newUsernamePolicy(path string) (*usernamePolicy, error) {
+	p := &usernamePolicy{reserved: map[string]bool{}}
+	for _, name := range reservedUsernames {
+		p.reserved[usernameKey(name)] = true
+	}
+
+	if path == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading username blocklist: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		if key := usernameKey(word); key != "" {
+			p.blocked = append(p.blocked, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading username blocklist: %w", err)
+	}
+	return p, nil
+}
+
+// check returns raw as it's stored, width and composition normalized, and
+// its key
+func (p *usernamePolicy) check(raw string) (string, string, error) {
+	if raw == "" {
+		return "", "", ErrMissingUsername
+	}
+
+	name, err := precis.UsernameCasePreserved.String(raw)
+	if err != nil {
+		return "", "", ErrBadUsername.withDetail("letters, digits and " + usernameSeparators + " only")
+	}
+
+	length := utf8.RuneCountInString(name)
+	if length > maxUsernameLength {
+		return "", "", ErrUsernameTooLong.withDetail(fmt.Sprintf("at most %d characters", maxUsernameLength))
+	}
+	if length < minUsernameLength {
+		return "", "", ErrUsernameTooShort.withDetail(fmt.Sprintf("at least %d characters", minUsernameLength))
+	}
+
+	script := -1
+	for i, r := range name {
+		switch {
+		case r >= '0' && r <= '9':
+		case strings.ContainsRune(usernameSeparators, r):
+			if i == 0 || i+utf8.RuneLen(r) == len(name) {
+				return "", "", ErrBadUsername.withDetail("can't start or end with " + string(r))
+			}
+		case unicode.IsLetter(r) && unicode.Is(unicode.Common, r):
+			// like the Japanese long vowel mark, fine in any script
+		case unicode.IsLetter(r):
+			s := scriptOf(r)
+			if s < 0 || (script >= 0 && s != script) {
+				return "", "", ErrBadUsername.withDetail("letters must all be from one script")
+			}
+			script = s
+		case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r):
+			if i == 0 {
+				return "", "", ErrBadUsername.withDetail("letters, digits and " + usernameSeparators + " only")
+			}
+		default:
+			return "", "", ErrBadUsername.withDetail("letters, digits and " + usernameSeparators + " only")
+		}
+	}
+
+	key := usernameKey(name)
+	if p.reserved[key] {
+		return "", "", ErrUsernameReserved
+	}
+	for _, word := range p.blocked {
+		if strings.Contains(key, word) {
+			return "", "", ErrUsernameNotAllowed
+		}
+	}
+	return name, key, nil
+}
+
+// scriptOf is r's index in usernameScripts, -1 if it's in none of them
+func scriptOf(r rune) int {
+	for i, tables := range usernameScripts {
+		for _, t := range tables {
+			if unicode.Is(t, r) {
+				return i
+			}
+		}
+	}
+	return -1
+}
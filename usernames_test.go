@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUsernameKeyLookAlikes(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"alice", "ALICE"},
+		{"alice", "AIice"},
+		{"alice", "a1ice"},
+		{"alice", "Alіce"}, // Cyrillic і
+		{"bobby", "B0bby"},
+		{"bobby", "Ｂｏｂｂｙ"},
+		{"bobby", "bob_by"},
+		{"jose", "josé"},
+		{"mike", "rnike"},
+		{"paypal", "раураl"}, // Cyrillic р, а, у
+	}
+	for _, tt := range tests {
+		if ka, kb := usernameKey(tt.a), usernameKey(tt.b); ka != kb {
+			t.Errorf("usernameKey(%q) = %q, usernameKey(%q) = %q, want them equal", tt.a, ka, tt.b, kb)
+		}
+	}
+}
+
+func TestUsernameKeyKeepsDifferentNames(t *testing.T) {
+	for _, pair := range [][2]string{{"alice", "alico"}, {"名前です", "名前てす"}, {"bob", "rob"}} {
+		if usernameKey(pair[0]) == usernameKey(pair[1]) {
+			t.Errorf("%q and %q share key %q", pair[0], pair[1], usernameKey(pair[0]))
+		}
+	}
+}
+
+func TestUsernamePolicyReserved(t *testing.T) {
+	p, err := newUsernamePolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, name := range reservedUsernames {
+		if len(name) >= minUsernameLength {
+			names = append(names, name)
+		}
+	}
+	names = append(names, "ADMIN", "Adm1n", "AdmIn", "OFFICIAL", "0fficial", "Root", "ad-min", "Ｍｏｄ")
+
+	for _, name := range names {
+		if _, _, err := p.check(name); !errors.Is(err, ErrUsernameReserved) {
+			t.Errorf("check(%q) = %v, want ErrUsernameReserved", name, err)
+		}
+	}
+}
+
+func TestUsernamePolicy(t *testing.T) {
+	p, err := newUsernamePolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.blocked = []string{usernameKey("darn")}
+
+	tests := []struct {
+		name string
+		want error
+	}{
+		{"alice", nil},
+		{"ユーザー名", nil},
+		{"", ErrMissingUsername},
+		{"ab", ErrUsernameTooShort},
+		{"seventeen_chars_x", ErrUsernameTooLong},
+		{"bob_", ErrBadUsername},
+		{"b ob", ErrBadUsername},
+		{"Pаypal", ErrBadUsername}, // Latin and Cyrillic
+		{"xxDARNxx", ErrUsernameNotAllowed},
+		{"xxd4rnxx", ErrUsernameNotAllowed},
+	}
+	for _, tt := range tests {
+		_, _, err := p.check(tt.name)
+		if (tt.want == nil && err != nil) || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("check(%q) = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}